@@ -6,13 +6,55 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 
+	kyaml "github.com/knadh/koanf/parsers/yaml"
+	"github.com/knadh/koanf/providers/env"
+	"github.com/knadh/koanf/providers/file"
 	"github.com/knadh/koanf/v2"
-	"gopkg.in/yaml.v3"
 )
 
+// configDirOverride is set by SetUserConfigDir.
+var configDirOverride string
+
+// SetUserConfigDir overrides the user config directory (e.g. from a flag).
+func SetUserConfigDir(dir string) {
+	configDirOverride = dir
+}
+
+// UserConfigDir returns the directory holding the user config and App API Key.
+// It is the first of:
+//
+//   - the directory set by SetUserConfigDir
+//   - $KION_CONFIG_DIR
+//   - $XDG_CONFIG_HOME/kion, unless only the legacy directory exists
+//   - ~/.config/kion (the legacy directory)
 func UserConfigDir() (string, error) {
+	if configDirOverride != "" {
+		return configDirOverride, nil
+	}
+	if dir := os.Getenv("KION_CONFIG_DIR"); dir != "" {
+		return dir, nil
+	}
+
+	legacyDir, err := legacyUserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		dir := filepath.Join(xdg, "kion")
+		// fall back to the legacy directory if it couldn't be migrated
+		if !exists(dir) && exists(legacyDir) {
+			return legacyDir, nil
+		}
+		return dir, nil
+	}
+	return legacyDir, nil
+}
+
+func legacyUserConfigDir() (string, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		return "", err
@@ -20,6 +62,67 @@ func UserConfigDir() (string, error) {
 	return filepath.Join(homeDir, ".config", "kion"), nil
 }
 
+// UserCacheDir returns the directory holding cached data, which is
+// $XDG_CACHE_HOME/kion or the platform's equivalent (see os.UserCacheDir).
+func UserCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "kion"), nil
+}
+
+// Migrate moves files from older locations: the legacy config directory to
+// $XDG_CONFIG_HOME/kion, and cached data from the config directory to the
+// cache directory. Migration is best effort; files that can't be moved are
+// left where they are.
+func Migrate() {
+	legacyDir, err := legacyUserConfigDir()
+	if err != nil {
+		return
+	}
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" && configDirOverride == "" && os.Getenv("KION_CONFIG_DIR") == "" {
+		dir := filepath.Join(xdg, "kion")
+		if dir != legacyDir && !exists(dir) && exists(legacyDir) {
+			if os.MkdirAll(xdg, 0700) == nil {
+				os.Rename(legacyDir, dir)
+			}
+		}
+	}
+
+	configDir, err := UserConfigDir()
+	if err != nil {
+		return
+	}
+	cacheDir, err := UserCacheDir()
+	if err != nil {
+		return
+	}
+	for _, name := range cacheFilenames {
+		oldName := filepath.Join(configDir, name)
+		newName := filepath.Join(cacheDir, name)
+		if !exists(oldName) || exists(newName) {
+			continue
+		}
+		if os.MkdirAll(cacheDir, 0700) != nil {
+			return
+		}
+		// caches can be rebuilt, so discard one that can't be moved
+		if os.Rename(oldName, newName) != nil {
+			os.Remove(oldName)
+		}
+	}
+}
+
+// cacheFilenames lists files once kept in the config directory that are now
+// kept in the cache directory.
+var cacheFilenames = []string{"credential_process_cache.yml"}
+
+func exists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
 func UserConfigName() (string, error) {
 	dir, err := UserConfigDir()
 	if err != nil {
@@ -28,14 +131,163 @@ func UserConfigName() (string, error) {
 	return filepath.Join(dir, "config.yml"), nil
 }
 
+// Layer is a source of config values: a config file, or the environment if
+// Path is empty. Name is used to refer to the layer on the command line.
+type Layer struct {
+	Name string
+	Path string
+}
+
+// isProject reports whether the layer is a kion.yml found from the working
+// directory rather than the user's own config.
+func (l Layer) isProject() bool {
+	return l.Name == "parent" || l.Name == "local"
+}
+
+func (l Layer) String() string {
+	if l.Path == "" {
+		return l.Name
+	}
+	return fmt.Sprintf("%v (%v)", l.Name, l.Path)
+}
+
+// Layers returns the config files in the order they are loaded. Values in later
+// layers override those in earlier layers.
+//
+// The user config is loaded first, followed by any kion.yml files found in
+// parent directories of the working directory, farthest first, and finally
+// kion.yml in the working directory, and then the environment. The search for
+// parent files stops at the root of a git repository, at the directory named by
+// KION_CONFIG_ROOT, at the home directory, or at the filesystem root. Parent and
+// local files are loaded only if they're trusted (see checkTrusted).
+//
+// Flags, which are loaded after all layers, override every layer.
+func Layers() ([]Layer, error) {
+	userConfigName, err := UserConfigName()
+	if err != nil {
+		return nil, err
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = ""
+	}
+	root := os.Getenv("KION_CONFIG_ROOT")
+	if root != "" {
+		root, err = filepath.Abs(root)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	parents := []Layer{}
+	for dir := wd; ; {
+		if dir == root || dir == home || isGitRoot(dir) {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+
+		name := filepath.Join(dir, localConfigFilename)
+		_, err := os.Stat(name)
+		if err == nil {
+			parents = append([]Layer{{Name: "parent", Path: name}}, parents...)
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	layers := []Layer{{Name: "user", Path: userConfigName}}
+	layers = append(layers, parents...)
+	layers = append(layers, Layer{Name: "local", Path: filepath.Join(wd, localConfigFilename)})
+	layers = append(layers, Layer{Name: "env"})
+	return layers, nil
+}
+
+const localConfigFilename = "kion.yml"
+
+func isGitRoot(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, ".git"))
+	return err == nil
+}
+
+// LookupLayer returns the layer with the given name. Only the user and local
+// layers can be looked up.
+func LookupLayer(name string) (Layer, error) {
+	if name != "user" && name != "local" {
+		return Layer{}, fmt.Errorf("unknown config file: %v (want user or local)", name)
+	}
+	layers, err := Layers()
+	if err != nil {
+		return Layer{}, err
+	}
+	for _, l := range layers {
+		if l.Name == name {
+			return l, nil
+		}
+	}
+	panic(fmt.Sprintf("missing layer: %v", name))
+}
+
+// Load reads the layer's values. If the layer's file doesn't exist, the error
+// wraps fs.ErrNotExist; if it isn't trusted, the error wraps ErrUntrusted.
+func (l Layer) Load() (*koanf.Koanf, error) {
+	k := koanf.New(".")
+	var err error
+	if l.isProject() {
+		err = checkTrusted(l.Path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if l.Path == "" {
+		err = k.Load(env.Provider(envPrefix, ".", EnvKey), nil)
+	} else {
+		err = k.Load(file.Provider(l.Path), kyaml.Parser())
+	}
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+const envPrefix = "KION_"
+
+// EnvKey maps an environment variable to the setting it configures, e.g.
+// KION_ACCOUNT_ID to account-id. Variables that don't name a known setting map
+// to "".
+func EnvKey(name string) string {
+	key := strings.ReplaceAll(strings.ToLower(strings.TrimPrefix(name, envPrefix)), "_", "-")
+	if _, ok := LookupSetting(key); !ok {
+		return ""
+	}
+	return key
+}
+
+// EnvVar returns the environment variable that configures the setting key.
+func EnvVar(key string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+}
+
 type Config struct {
 	*koanf.Koanf
+
+	// Sources maps each key to the layer its value came from.
+	Sources map[string]Layer
+	// Loaded lists the layers that were loaded.
+	Loaded []Layer
 }
 
 func (c *Config) DurationErr(path string) (time.Duration, error) {
 	v := c.Duration(path)
 	if v == 0 {
-		return 0, fmt.Errorf("missing config value: %v", path)
+		return 0, c.missing(path, "zero")
 	}
 	return v, nil
 }
@@ -43,7 +295,7 @@ func (c *Config) DurationErr(path string) (time.Duration, error) {
 func (c *Config) IntErr(path string) (int, error) {
 	v := c.Int(path)
 	if v == 0 {
-		return 0, fmt.Errorf("missing config value: %v", path)
+		return 0, c.missing(path, "zero")
 	}
 	return v, nil
 }
@@ -51,60 +303,40 @@ func (c *Config) IntErr(path string) (int, error) {
 func (c *Config) StringErr(path string) (string, error) {
 	v := c.String(path)
 	if v == "" {
-		return "", fmt.Errorf("missing config value: %v", path)
+		return "", c.missing(path, "empty")
 	}
 	return v, nil
 }
 
-const keyConfigFilename = "key.yml"
-
-type KeyConfig struct {
-	Key     string
-	Created time.Time
-}
-
-func LoadKeyConfig() (*KeyConfig, error) {
-	dir, err := UserConfigDir()
-	if err != nil {
-		return nil, err
-	}
-
-	config := KeyConfig{}
-
-	name := filepath.Join(dir, keyConfigFilename)
-	f, err := os.Open(name)
-	if errors.Is(err, fs.ErrNotExist) {
-		return &config, nil
-	} else if err != nil {
-		return nil, err
-	}
-	defer f.Close()
-
-	err = yaml.NewDecoder(f).Decode(&config)
-	if err != nil {
-		return nil, err
+// SetKeys returns the sorted keys that have a value, whether from a file, the
+// environment, a flag, or a flag's default. Flags that aren't settings (e.g.
+// --explain) and empty defaults are left out.
+func (c *Config) SetKeys() []string {
+	keys := []string{}
+	for key, source := range c.Sources {
+		if source.Name == "flag" || source.Name == "default" {
+			if _, ok := LookupSetting(key); !ok {
+				continue
+			}
+		}
+		if source.Name == "default" && c.String(key) == "" {
+			continue
+		}
+		keys = append(keys, key)
 	}
-
-	return &config, err
+	sort.Strings(keys)
+	return keys
 }
 
-func (c *KeyConfig) Save() error {
-	dir, err := UserConfigDir()
-	if err != nil {
-		return err
-	}
-
-	name := filepath.Join(dir, keyConfigFilename)
-	err = os.MkdirAll(dir, 0700)
-	if err != nil {
-		return err
+// missing returns an error for a value that is absent or has the zero value,
+// describing the latter as what.
+func (c *Config) missing(path string, what string) error {
+	source, ok := c.Sources[path]
+	if !c.Exists(path) || source.Name == "default" {
+		return fmt.Errorf("missing config value: %v", path)
 	}
-
-	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
-	if err != nil {
-		return err
+	if ok {
+		return fmt.Errorf("config value %v is %v (from %v)", path, what, source)
 	}
-	defer f.Close()
-
-	return yaml.NewEncoder(f).Encode(c)
+	return fmt.Errorf("config value %v is %v", path, what)
 }
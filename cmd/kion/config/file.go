@@ -0,0 +1,238 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// File is a YAML config file that can be edited without losing comments,
+// formatting, or keys that aren't edited. Only top-level keys are supported.
+type File struct {
+	Name string
+	// Mode is the permissions given to the file if Save creates it; zero means
+	// 0600. An existing file keeps its permissions.
+	Mode fs.FileMode
+	doc  yaml.Node
+	// comment holds the comments of a file with no settings, which yaml.v3
+	// doesn't keep, so that they survive Set and Save.
+	comment string
+}
+
+// LoadFile reads the config file called name. If the file doesn't exist,
+// LoadFile returns an empty File that is created when saved.
+func LoadFile(name string) (*File, error) {
+	f := &File{Name: name}
+
+	data, err := os.ReadFile(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return f, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = yaml.Unmarshal(data, &f.doc)
+	if err != nil {
+		return nil, fmt.Errorf("bad config in %v: %w", name, err)
+	}
+	if isEmptyDocument(&f.doc) {
+		f.doc = yaml.Node{}
+		f.comment = comments(data)
+		return f, nil
+	}
+	if f.mapping() == nil {
+		return nil, fmt.Errorf("bad config in %v: not a mapping", name)
+	}
+
+	return f, nil
+}
+
+// isEmptyDocument reports whether doc has no content, e.g. because it has only
+// comments.
+func isEmptyDocument(doc *yaml.Node) bool {
+	if len(doc.Content) == 0 {
+		return true
+	}
+	c := doc.Content[0]
+	return c.Kind == yaml.ScalarNode && c.Tag == "!!null"
+}
+
+// comments returns the comment lines of an empty document, keeping blank lines
+// between them.
+func comments(data []byte) string {
+	lines := []string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Trim(strings.Join(lines, "\n"), "\n")
+}
+
+// mapping returns the top-level mapping node, or nil if the file is empty.
+func (f *File) mapping() *yaml.Node {
+	if f.doc.Kind != yaml.DocumentNode || len(f.doc.Content) == 0 {
+		return nil
+	}
+	m := f.doc.Content[0]
+	if m.Kind != yaml.MappingNode {
+		return nil
+	}
+	return m
+}
+
+// Get returns the value of key as a string, and whether key is present with a
+// scalar value.
+func (f *File) Get(key string) (string, bool) {
+	m := f.mapping()
+	if m == nil {
+		return "", false
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			v := m.Content[i+1]
+			return v.Value, v.Kind == yaml.ScalarNode
+		}
+	}
+	return "", false
+}
+
+// Set sets key to value, replacing any existing value in place or adding key at
+// the end of the file.
+func (f *File) Set(key string, value interface{}) error {
+	var v yaml.Node
+	err := v.Encode(value)
+	if err != nil {
+		return err
+	}
+
+	m := f.mapping()
+	if m == nil {
+		m = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		f.doc = yaml.Node{Kind: yaml.DocumentNode, HeadComment: f.comment, Content: []*yaml.Node{m}}
+		f.comment = ""
+	}
+
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			// keep comments attached to the old value
+			v.HeadComment = m.Content[i+1].HeadComment
+			v.LineComment = m.Content[i+1].LineComment
+			v.FootComment = m.Content[i+1].FootComment
+			*m.Content[i+1] = v
+			return nil
+		}
+	}
+
+	m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, &v)
+	return nil
+}
+
+// Unset removes key, reporting whether it was present.
+func (f *File) Unset(key string) bool {
+	m := f.mapping()
+	if m == nil {
+		return false
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			m.Content = append(m.Content[:i], m.Content[i+2:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Keys returns the top-level keys in the order they appear.
+func (f *File) Keys() []string {
+	m := f.mapping()
+	if m == nil {
+		return nil
+	}
+	keys := []string{}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		keys = append(keys, m.Content[i].Value)
+	}
+	return keys
+}
+
+// Backup copies the file to Name + ".bak", returning the backup's name. If the
+// file doesn't exist, Backup does nothing and returns "".
+func (f *File) Backup() (string, error) {
+	data, err := os.ReadFile(f.Name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	name := f.Name + ".bak"
+	return name, os.WriteFile(name, data, 0600)
+}
+
+// Save writes the file, replacing the existing file atomically. If the file is
+// a symlink, the file it links to is replaced instead.
+func (f *File) Save() error {
+	name := f.Name
+	if resolved, err := filepath.EvalSymlinks(name); err == nil {
+		name = resolved
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	dir := filepath.Dir(name)
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	mode := f.Mode
+	if info, err := os.Stat(name); err == nil {
+		mode = info.Mode().Perm()
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	} else if mode == 0 {
+		mode = 0600
+	}
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	// CreateTemp uses 0600, which the rename would keep
+	err = tmp.Chmod(mode)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if f.mapping() != nil {
+		enc := yaml.NewEncoder(tmp)
+		enc.SetIndent(2)
+		err = enc.Encode(&f.doc)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+	} else if f.comment != "" {
+		_, err = fmt.Fprintln(tmp, f.comment)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), name)
+}
@@ -0,0 +1,134 @@
+package config
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestFileCommentsOnly(t *testing.T) {
+	for _, data := range []string{"# host: kion.example.com\n", "---\n# host: kion.example.com\n"} {
+		name := filepath.Join(t.TempDir(), "kion.yml")
+		err := os.WriteFile(name, []byte(data), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		problems, err := Layer{Name: "local", Path: name}.Validate()
+		if err != nil || len(problems) != 0 {
+			t.Fatalf("%q: got %v, %v (want no problems)", data, problems, err)
+		}
+		f, err := LoadFile(name)
+		if err != nil {
+			t.Fatalf("%q: %v", data, err)
+		}
+
+		err = f.Set("idms", 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = f.Save()
+		if err != nil {
+			t.Fatal(err)
+		}
+		saved, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "# host: kion.example.com\n\nidms: 2\n"
+		if string(saved) != want {
+			t.Errorf("%q: saved %q (want %q)", data, saved, want)
+		}
+	}
+}
+
+func TestFileSaveSymlink(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "dotfiles", "kion.yml")
+	err := os.MkdirAll(filepath.Dir(target), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(target, []byte("host: kion.example.com\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "kion.yml")
+	err = os.Symlink(target, link)
+	if err != nil {
+		t.Skip(err)
+	}
+
+	f, err := LoadFile(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.Set("idms", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Lstat(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		t.Fatal("symlink replaced by a regular file")
+	}
+	saved, err := os.ReadFile(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(saved) != "host: kion.example.com\nidms: 2\n" {
+		t.Errorf("target has %q", saved)
+	}
+}
+
+func TestFileSaveMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no permission bits on Windows")
+	}
+	dir := t.TempDir()
+
+	f, err := LoadFile(filepath.Join(dir, "kion.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Mode = 0644
+	err = f.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMode(t, f.Name, 0644)
+
+	err = os.Chmod(f.Name, 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.Set("idms", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMode(t, f.Name, 0640)
+}
+
+func checkMode(t *testing.T, name string, want fs.FileMode) {
+	t.Helper()
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != want {
+		t.Errorf("%v has mode %v (want %v)", name, info.Mode().Perm(), want)
+	}
+}
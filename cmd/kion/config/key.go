@@ -0,0 +1,264 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/zalando/go-keyring"
+	"gopkg.in/yaml.v3"
+)
+
+const keyConfigFilename = "key.yml"
+
+// Values of KeyConfig.Storage.
+const (
+	KeyStorageFile    = "file"
+	KeyStorageKeyring = "keyring"
+)
+
+// The keyring service under which App API Keys are stored. The keyring user is
+// the key's host and ID (see keyringUser), which stay the same if the config
+// directory moves.
+const keyringService = "kion-app-api-key"
+
+// KeyConfig holds the App API Key and its metadata. The metadata is saved in
+// key.yml; the key itself is saved there too unless Storage is
+// KeyStorageKeyring, in which case it's saved in the system keyring.
+type KeyConfig struct {
+	ID      int    `yaml:",omitempty"`
+	Name    string `yaml:",omitempty"`
+	Host    string `yaml:",omitempty"`
+	Storage string `yaml:",omitempty"`
+	Key     string `yaml:",omitempty"`
+	Created time.Time
+	// Expiry is the expiry reported by Kion, or zero if Kion didn't report it.
+	Expiry time.Time `yaml:",omitempty"`
+
+	// PendingID and PendingKey hold a new key from the moment Kion returns it
+	// until its metadata is saved, so that the key isn't lost if creation or
+	// rotation is interrupted.
+	PendingID  int    `yaml:",omitempty"`
+	PendingKey string `yaml:",omitempty"`
+
+	// PreviousIDs lists keys this config used before the current key, which
+	// "kion key prune" may revoke without asking about each one.
+	PreviousIDs []int `yaml:",omitempty,flow"`
+}
+
+func keyConfigName() (string, error) {
+	dir, err := UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, keyConfigFilename), nil
+}
+
+func LoadKeyConfig() (*KeyConfig, error) {
+	name, err := keyConfigName()
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := readKeyConfig(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Storage == KeyStorageKeyring {
+		// a key config without a key (e.g. from setup with user credentials)
+		// has neither an ID nor a creation time
+		if config.ID != 0 || !config.Created.IsZero() {
+			config.Key, err = getKeyringSecret(keyringUser(config.Host, config.ID), name)
+			if err != nil {
+				return nil, fmt.Errorf("reading App API Key %v from keyring: %w", config.ID, err)
+			}
+		}
+		if config.PendingID != 0 {
+			config.PendingKey, err = getKeyringSecret(keyringUser(config.Host, config.PendingID), name+pendingSuffix)
+			if err != nil {
+				return nil, fmt.Errorf("reading App API Key %v from keyring: %w", config.PendingID, err)
+			}
+		}
+	}
+
+	return config, nil
+}
+
+// readKeyConfig reads key.yml without reading keys from the keyring. A missing
+// file yields an empty config.
+func readKeyConfig(name string) (*KeyConfig, error) {
+	config := KeyConfig{}
+
+	f, err := os.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &config, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	err = yaml.NewDecoder(f).Decode(&config)
+	if err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// Save saves the key config. key.yml is replaced atomically, so it's never left
+// partly written.
+func (c *KeyConfig) Save() error {
+	dir, err := UserConfigDir()
+	if err != nil {
+		return err
+	}
+	name, err := keyConfigName()
+	if err != nil {
+		return err
+	}
+
+	// keyring entries of the previous config that this one doesn't use are
+	// deleted once the new config is saved
+	previous, err := readKeyConfig(name)
+	if err != nil {
+		previous = &KeyConfig{}
+	}
+
+	saved := *c
+	entries := c.keyringEntries()
+	if c.Storage == KeyStorageKeyring {
+		for user, secret := range entries {
+			err = keyring.Set(keyringService, user, secret)
+			if err != nil {
+				return fmt.Errorf("saving App API Key to keyring: %w", err)
+			}
+		}
+		saved.Key = ""
+		saved.PendingKey = ""
+	} else {
+		entries = nil
+	}
+
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(dir, keyConfigFilename+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	err = yaml.NewEncoder(f).Encode(&saved)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	// make sure the data is on disk before the rename makes it visible
+	err = f.Sync()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(f.Name(), name)
+	if err != nil {
+		return err
+	}
+
+	if previous.Storage == KeyStorageKeyring {
+		stale := []string{keyringUser(previous.Host, previous.ID), keyringUser(previous.Host, previous.PendingID), name, name + pendingSuffix}
+		for _, user := range stale {
+			if _, ok := entries[user]; !ok {
+				deleteKeyringSecret(user)
+			}
+		}
+	}
+	return nil
+}
+
+// keyringUser returns the keyring user under which the key with the given host
+// and ID is stored.
+func keyringUser(host string, id int) string {
+	return fmt.Sprintf("%s/%d", host, id)
+}
+
+// keyringEntries returns the keys to store in the keyring by keyring user.
+func (c *KeyConfig) keyringEntries() map[string]string {
+	entries := map[string]string{}
+	if c.Key != "" {
+		entries[keyringUser(c.Host, c.ID)] = c.Key
+	}
+	if c.PendingKey != "" {
+		entries[keyringUser(c.Host, c.PendingID)] = c.PendingKey
+	}
+	return entries
+}
+
+// Older versions stored keys under the name of key.yml, and pending keys under
+// that name plus pendingSuffix. Such keys are read if there's no entry for the
+// key's host and ID, and deleted when the config is next saved.
+const pendingSuffix = "#pending"
+
+// getKeyringSecret returns the secret stored for user, or for legacyUser if
+// there's none for user.
+func getKeyringSecret(user string, legacyUser string) (string, error) {
+	secret, err := keyring.Get(keyringService, user)
+	if errors.Is(err, keyring.ErrNotFound) {
+		secret, err = keyring.Get(keyringService, legacyUser)
+	}
+	return secret, err
+}
+
+func deleteKeyringSecret(user string) error {
+	err := keyring.Delete(keyringService, user)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// SetStorage moves the key to the given storage ("" means KeyStorageFile),
+// saving it if the storage changed. If saving fails, the key stays where it was.
+func (c *KeyConfig) SetStorage(storage string) error {
+	if storage == "" {
+		storage = KeyStorageFile
+	}
+	if storage != KeyStorageFile && storage != KeyStorageKeyring {
+		return fmt.Errorf("invalid app-api-key-storage: %v (want %v or %v)", storage, KeyStorageFile, KeyStorageKeyring)
+	}
+
+	current := c.Storage
+	if current == "" {
+		current = KeyStorageFile
+	}
+	if storage == current || c.Key == "" {
+		return nil
+	}
+
+	// Save removes the key from the keyring once it's safely in the file
+	previous := c.Storage
+	c.Storage = storage
+	err := c.Save()
+	if err != nil {
+		c.Storage = previous
+		return err
+	}
+	return nil
+}
+
+// LockKeyConfig acquires an exclusive lock on key.yml that is shared among
+// processes, waiting up to timeout. The lock is released by calling unlock.
+// Callers should reload the key config after acquiring the lock since another
+// process may have changed it.
+func LockKeyConfig(timeout time.Duration) (unlock func(), err error) {
+	return lockFile(keyConfigFilename, timeout)
+}
@@ -0,0 +1,97 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zalando/go-keyring"
+)
+
+func TestKeyConfigPending(t *testing.T) {
+	SetUserConfigDir(t.TempDir())
+	defer SetUserConfigDir("")
+
+	keyCfg := &KeyConfig{ID: 1, Key: "old", Created: time.Now().UTC().Truncate(time.Second), PendingID: 2, PendingKey: "new", PreviousIDs: []int{7}}
+	err := keyCfg.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadKeyConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, keyCfg) {
+		t.Fatalf("got %+v (want %+v)", loaded, keyCfg)
+	}
+}
+
+func TestKeyConfigKeyring(t *testing.T) {
+	keyring.MockInit()
+	SetUserConfigDir(t.TempDir())
+	defer SetUserConfigDir("")
+
+	keyCfg := &KeyConfig{ID: 1, Host: "kion.example.com", Storage: KeyStorageKeyring, Key: "old", Created: time.Now().UTC().Truncate(time.Second)}
+	err := keyCfg.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the entry doesn't depend on the config directory
+	SetUserConfigDir(t.TempDir())
+	err = keyCfg.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadKeyConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, keyCfg) {
+		t.Fatalf("got %+v (want %+v)", loaded, keyCfg)
+	}
+
+	// the old key's entry is deleted when it's replaced
+	keyCfg.ID = 2
+	keyCfg.Key = "new"
+	err = keyCfg.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = keyring.Get(keyringService, keyringUser("kion.example.com", 1))
+	if err != keyring.ErrNotFound {
+		t.Fatalf("old key not deleted: %v", err)
+	}
+
+	err = keyring.Delete(keyringService, keyringUser("kion.example.com", 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = LoadKeyConfig()
+	if err == nil {
+		t.Fatal("missing keyring entry not reported")
+	}
+}
+
+func TestLockKeyConfig(t *testing.T) {
+	SetUserConfigDir(t.TempDir())
+	defer SetUserConfigDir("")
+
+	unlock, err := LockKeyConfig(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = LockKeyConfig(200 * time.Millisecond)
+	if err == nil {
+		t.Fatal("acquired lock twice")
+	}
+
+	unlock()
+	unlock, err = LockKeyConfig(time.Second)
+	if err != nil {
+		t.Fatalf("lock not released: %v", err)
+	}
+	unlock()
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// lockFile acquires an exclusive lock on the named file in the user config
+// directory, waiting up to timeout. The lock is released by calling unlock. It's
+// an OS file lock, so the OS releases it if the process dies while holding it.
+func lockFile(filename string, timeout time.Duration) (unlock func(), err error) {
+	dir, err := UserConfigDir()
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	name := filepath.Join(dir, filename+".lock")
+
+	// the lock file is left in place; removing it would let a process that
+	// opened it before the removal lock a different file than later ones
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		locked, err := tryLock(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("locking %v: %w", name, err)
+		}
+		if locked {
+			return func() {
+				unlockFile(f)
+				f.Close()
+			}, nil
+		}
+		if time.Now().After(deadline) {
+			f.Close()
+			return nil, fmt.Errorf("timed out waiting for lock %v held by another kion process", name)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+type Type int
+
+const (
+	TypeString Type = iota
+	TypeInt
+	TypeBool
+	TypeDuration
+)
+
+func (t Type) String() string {
+	switch t {
+	case TypeString:
+		return "string"
+	case TypeInt:
+		return "int"
+	case TypeBool:
+		return "bool"
+	case TypeDuration:
+		return "duration"
+	default:
+		return fmt.Sprintf("Type(%d)", int(t))
+	}
+}
+
+// Setting describes a key that may appear in a config file.
+type Setting struct {
+	Key         string
+	Type        Type
+	Description string
+}
+
+// commandSettings are settings whose values are run as commands. They're
+// ignored in kion.yml files, which may come from someone else (e.g. a cloned
+// repository).
+var commandSettings = map[string]bool{
+	"mfa-command":      true,
+	"password-command": true,
+}
+
+// RunsCommand reports whether the setting's value is run as a command, and so
+// can't be set in kion.yml.
+func (s Setting) RunsCommand() bool {
+	return commandSettings[s.Key]
+}
+
+// Settings lists the known config file keys.
+var Settings = []Setting{
+	{"account-id", TypeString, "AWS account ID"},
+	{"app-api-key-duration", TypeDuration, "duration of App API Keys"},
+	{"app-api-key-name", TypeString, "template for the names of new App API Keys"},
+	{"app-api-key-storage", TypeString, "where to store the App API Key (file or keyring)"},
+	{"app-api-key-warning-window", TypeDuration, "warn when an App API Key that isn't rotated automatically expires within this duration"},
+	{"auto-recreate-app-api-key", TypeBool, "create a new App API Key with user credentials when the key expires"},
+	{"cloud-access-role", TypeString, "cloud access role"},
+	{"console-issuer", TypeString, "template for the URL visited when the console session expires"},
+	{"host", TypeString, "Kion host"},
+	{"idms", TypeInt, "ID management system ID"},
+	{"login-method", TypeString, "how to log in with user credentials (password or sso)"},
+	{"mfa-command", TypeString, "command that prints an MFA code for password logins"},
+	{"password-command", TypeString, "command that prints the user's password, used instead of password-storage"},
+	{"password-storage", TypeString, "where to store the user's password (keyring or file)"},
+	{"reauth-lifetime", TypeDuration, "how long console --reauth-url serves its endpoint"},
+	{"rotate-app-api-keys", TypeBool, "automatically rotate App API Keys"},
+	{"session-duration", TypeDuration, "duration of temporary credentials"},
+	{"username", TypeString, "username"},
+}
+
+// LookupSetting returns the setting with the given key.
+func LookupSetting(key string) (Setting, bool) {
+	for _, s := range Settings {
+		if s.Key == key {
+			return s, true
+		}
+	}
+	return Setting{}, false
+}
+
+// Parse converts v to a value of the setting's type.
+func (s Setting) Parse(v string) (interface{}, error) {
+	var out interface{}
+	var err error
+	switch s.Type {
+	case TypeString:
+		out = v
+	case TypeInt:
+		out, err = strconv.Atoi(v)
+	case TypeBool:
+		out, err = strconv.ParseBool(v)
+	case TypeDuration:
+		out, err = time.ParseDuration(v)
+	default:
+		panic(fmt.Sprintf("unexpected type: %v", s.Type))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %v for %v: %q", s.Type, s.Key, v)
+	}
+	return out, nil
+}
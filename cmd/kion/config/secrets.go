@@ -0,0 +1,204 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/zalando/go-keyring"
+	"golang.org/x/crypto/scrypt"
+)
+
+// The secrets file holds secrets such as passwords where the system keyring is
+// unavailable. It is encrypted with a key derived from a passphrase.
+const secretsFilename = "secrets.enc"
+
+// Key derivation parameters for the secrets file (scrypt).
+const (
+	secretsScryptN   = 1 << 15
+	secretsScryptR   = 8
+	secretsScryptP   = 1
+	secretsKeyLength = 32
+)
+
+// secretsLockTimeout is how long to wait for another process to finish
+// updating the secrets file.
+const secretsLockTimeout = 10 * time.Second
+
+// ErrWrongPassphrase is returned when the secrets file can't be decrypted.
+var ErrWrongPassphrase = errors.New("can't decrypt secrets file: wrong passphrase?")
+
+func SecretsName() (string, error) {
+	dir, err := UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, secretsFilename), nil
+}
+
+// secretsFile is the on-disk form of the secrets file. Data is the encrypted
+// JSON of a map from secret names to secrets.
+type secretsFile struct {
+	Salt  []byte
+	Nonce []byte
+	Data  []byte
+}
+
+// GetSecret returns the named secret from the secrets file. Like the keyring,
+// it returns keyring.ErrNotFound if there is no such secret.
+func GetSecret(passphrase string, name string) (string, error) {
+	secrets, err := loadSecrets(passphrase)
+	if err != nil {
+		return "", err
+	}
+	secret, ok := secrets[name]
+	if !ok {
+		return "", keyring.ErrNotFound
+	}
+	return secret, nil
+}
+
+// SetSecret saves the named secret in the secrets file, or deletes it if
+// secret is empty. A lock keeps concurrent processes from losing each other's
+// changes.
+func SetSecret(passphrase string, name string, secret string) error {
+	unlock, err := lockFile(secretsFilename, secretsLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	secrets, err := loadSecrets(passphrase)
+	if err != nil {
+		return err
+	}
+	if secret == "" {
+		delete(secrets, name)
+	} else {
+		secrets[name] = secret
+	}
+	return saveSecrets(passphrase, secrets)
+}
+
+func loadSecrets(passphrase string) (map[string]string, error) {
+	name, err := SecretsName()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return map[string]string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	file := secretsFile{}
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		return nil, fmt.Errorf("reading %v: %w", name, err)
+	}
+
+	aead, err := secretsCipher(passphrase, file.Salt)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, file.Nonce, file.Data, nil)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+
+	secrets := map[string]string{}
+	err = json.Unmarshal(plaintext, &secrets)
+	if err != nil {
+		return nil, fmt.Errorf("reading %v: %w", name, err)
+	}
+	return secrets, nil
+}
+
+func saveSecrets(passphrase string, secrets map[string]string) error {
+	dir, err := UserConfigDir()
+	if err != nil {
+		return err
+	}
+	name, err := SecretsName()
+	if err != nil {
+		return err
+	}
+
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+
+	// a fresh salt and nonce each time
+	file := secretsFile{
+		Salt:  make([]byte, 16),
+		Nonce: make([]byte, 12),
+	}
+	_, err = rand.Read(file.Salt)
+	if err != nil {
+		return err
+	}
+	_, err = rand.Read(file.Nonce)
+	if err != nil {
+		return err
+	}
+	aead, err := secretsCipher(passphrase, file.Salt)
+	if err != nil {
+		return err
+	}
+	file.Data = aead.Seal(nil, file.Nonce, plaintext, nil)
+
+	data, err := json.Marshal(&file)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(dir, secretsFilename+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(data)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Sync()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), name)
+}
+
+func secretsCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, secretsScryptN, secretsScryptR, secretsScryptP, secretsKeyLength)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/zalando/go-keyring"
+)
+
+func TestSecrets(t *testing.T) {
+	SetUserConfigDir(t.TempDir())
+	defer SetUserConfigDir("")
+
+	_, err := GetSecret("passphrase", "a")
+	if !errors.Is(err, keyring.ErrNotFound) {
+		t.Fatalf("got %v (want keyring.ErrNotFound)", err)
+	}
+
+	err = SetSecret("passphrase", "a", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := GetSecret("passphrase", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret != "secret" {
+		t.Fatalf("got %q (want %q)", secret, "secret")
+	}
+
+	_, err = GetSecret("wrong", "a")
+	if !errors.Is(err, ErrWrongPassphrase) {
+		t.Fatalf("got %v (want ErrWrongPassphrase)", err)
+	}
+
+	err = SetSecret("passphrase", "a", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = GetSecret("passphrase", "a")
+	if !errors.Is(err, keyring.ErrNotFound) {
+		t.Fatalf("got %v (want keyring.ErrNotFound)", err)
+	}
+}
+
+func TestSetSecretConcurrent(t *testing.T) {
+	SetUserConfigDir(t.TempDir())
+	defer SetUserConfigDir("")
+
+	names := []string{"a", "b", "c", "d"}
+	errs := make(chan error, len(names))
+	for _, name := range names {
+		go func(name string) {
+			errs <- SetSecret("passphrase", name, "secret")
+		}(name)
+	}
+	for range names {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// without the lock, a write could drop another's secret
+	for _, name := range names {
+		_, err := GetSecret("passphrase", name)
+		if err != nil {
+			t.Errorf("%v: %v", name, err)
+		}
+	}
+}
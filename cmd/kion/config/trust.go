@@ -0,0 +1,7 @@
+package config
+
+import "errors"
+
+// ErrUntrusted is returned when loading a kion.yml that another user could have
+// written, since a config file can name the host the App API Key is sent to.
+var ErrUntrusted = errors.New("untrusted config file")
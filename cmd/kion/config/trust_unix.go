@@ -0,0 +1,26 @@
+//go:build !windows
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// checkTrusted returns an error wrapping ErrUntrusted if the file called name
+// isn't owned by the current user or is writable by other users. A missing
+// file is left for the caller to report.
+func checkTrusted(name string) error {
+	info, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); !ok || int(stat.Uid) != os.Getuid() {
+		return fmt.Errorf("%w: owned by another user", ErrUntrusted)
+	}
+	if info.Mode().Perm()&0022 != 0 {
+		return fmt.Errorf("%w: writable by other users (mode %v)", ErrUntrusted, info.Mode().Perm())
+	}
+	return nil
+}
@@ -0,0 +1,256 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/knadh/koanf/providers/posflag"
+	"github.com/knadh/koanf/v2"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// Problem is an error or warning about a value in a config layer.
+type Problem struct {
+	Layer   Layer
+	Line    int // 0 if unknown
+	Message string
+	Warning bool
+}
+
+func (p Problem) Error() string {
+	loc := p.Layer.String()
+	if p.Layer.Path != "" {
+		loc = p.Layer.Path
+		if p.Line > 0 {
+			loc = fmt.Sprintf("%v:%d", loc, p.Line)
+		}
+	}
+	return fmt.Sprintf("%v: %v", loc, p.Message)
+}
+
+// Load loads and merges layers, recording the source of each value. Layers
+// whose files don't exist are skipped. Problems with the layers are returned
+// rather than failing the load, so that commands that repair the config can
+// still run: unknown keys produce warnings, invalid values produce errors,
+// layers that can't be parsed produce errors and are skipped, and untrusted
+// layers produce warnings and are skipped.
+func Load(layers []Layer) (*Config, []Problem, error) {
+	cfg := &Config{
+		Koanf:   koanf.New("."),
+		Sources: map[string]Layer{},
+	}
+	problems := []Problem{}
+
+	for _, layer := range layers {
+		k, err := layer.Load()
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if errors.Is(err, ErrUntrusted) {
+			problems = append(problems, Problem{Layer: layer, Message: fmt.Sprintf("skipped: %v", err), Warning: true})
+			continue
+		} else if err != nil {
+			problems = append(problems, Problem{Layer: layer, Message: fmt.Sprintf("bad config: %v", err)})
+			continue
+		}
+
+		layerProblems, err := layer.Validate()
+		if err != nil {
+			problems = append(problems, Problem{Layer: layer, Message: fmt.Sprintf("bad config: %v", err)})
+			continue
+		}
+		problems = append(problems, layerProblems...)
+		if layer.isProject() {
+			for key := range commandSettings {
+				k.Delete(key)
+			}
+		}
+
+		err = cfg.Merge(k)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, key := range k.Keys() {
+			cfg.Sources[key] = layer
+		}
+		cfg.Loaded = append(cfg.Loaded, layer)
+	}
+
+	return cfg, problems, nil
+}
+
+// LoadFlags loads flags over the config. Flags set on the command line override
+// all layers; defaults of other flags are loaded only if the config lacks them.
+func (c *Config) LoadFlags(flags *pflag.FlagSet) error {
+	err := c.Load(posflag.Provider(flags, ".", c.Koanf), nil)
+	if err != nil {
+		return err
+	}
+	flags.VisitAll(func(f *pflag.Flag) {
+		if f.Changed {
+			c.Sources[f.Name] = Layer{Name: "flag"}
+		} else if _, ok := c.Sources[f.Name]; !ok {
+			c.Sources[f.Name] = Layer{Name: "default"}
+		}
+	})
+	return nil
+}
+
+// Validate checks the layer's keys and values against Settings. Unknown keys
+// are reported as warnings and invalid values as errors. A missing file has no
+// problems.
+func (l Layer) Validate() ([]Problem, error) {
+	if l.Path == "" {
+		return l.validateEnv(), nil
+	}
+
+	data, err := os.ReadFile(l.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var doc yaml.Node
+	err = yaml.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, err
+	}
+	if isEmptyDocument(&doc) {
+		return nil, nil
+	}
+	m := doc.Content[0]
+	if m.Kind != yaml.MappingNode {
+		return []Problem{{Layer: l, Line: m.Line, Message: "not a mapping"}}, nil
+	}
+
+	problems := []Problem{}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		key, value := m.Content[i], m.Content[i+1]
+		setting, ok := LookupSetting(key.Value)
+		if !ok {
+			message := fmt.Sprintf("unknown key %q", key.Value)
+			if suggestion := suggest(key.Value); suggestion != "" {
+				message += fmt.Sprintf(" (did you mean %q?)", suggestion)
+			}
+			problems = append(problems, Problem{Layer: l, Line: key.Line, Message: message, Warning: true})
+			continue
+		}
+		if l.isProject() && commandSettings[key.Value] {
+			message := fmt.Sprintf("ignoring %v; it can only be set in the user config, the environment, or a flag", key.Value)
+			problems = append(problems, Problem{Layer: l, Line: key.Line, Message: message, Warning: true})
+			continue
+		}
+		err := setting.check(value)
+		if err != nil {
+			problems = append(problems, Problem{Layer: l, Line: value.Line, Message: err.Error()})
+		}
+	}
+	return problems, nil
+}
+
+// validateEnv checks the values of environment variables that name known
+// settings. Other KION_ variables are ignored since they may be used for other
+// purposes (e.g. KION_CONFIG_DIR).
+func (l Layer) validateEnv() []Problem {
+	problems := []Problem{}
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		if !strings.HasPrefix(name, envPrefix) {
+			continue
+		}
+		setting, ok := LookupSetting(EnvKey(name))
+		if !ok {
+			continue
+		}
+		_, err := setting.Parse(value)
+		if err != nil {
+			problems = append(problems, Problem{Layer: l, Message: fmt.Sprintf("%v: %v", name, err)})
+		}
+	}
+	return problems
+}
+
+// check checks that n holds a value of the setting's type. A null value is
+// allowed and treated as empty.
+func (s Setting) check(n *yaml.Node) error {
+	if n.Kind != yaml.ScalarNode {
+		return fmt.Errorf("invalid %v for %v: not a single value", s.Type, s.Key)
+	}
+	if n.Tag == "!!null" {
+		return nil
+	}
+
+	var err error
+	switch s.Type {
+	case TypeString:
+	case TypeInt:
+		var v int
+		err = n.Decode(&v)
+	case TypeBool:
+		var v bool
+		err = n.Decode(&v)
+	case TypeDuration:
+		var v time.Duration
+		err = n.Decode(&v)
+	default:
+		panic(fmt.Sprintf("unexpected type: %v", s.Type))
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %v for %v: %q", s.Type, s.Key, n.Value)
+	}
+	return nil
+}
+
+// suggest returns the known key closest to key if it's close enough to be a
+// likely typo, or "".
+func suggest(key string) string {
+	best := ""
+	// allow two edits, or more for longer keys
+	bestDistance := 3
+	if len(key)/3 >= bestDistance {
+		bestDistance = len(key)/3 + 1
+	}
+	for _, s := range Settings {
+		d := editDistance(key, s.Key)
+		if d < bestDistance {
+			best, bestDistance = s.Key, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(first int, rest ...int) int {
+	m := first
+	for _, v := range rest {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
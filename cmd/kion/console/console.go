@@ -8,10 +8,12 @@ import (
 	"html/template"
 	"net/http"
 	"net/url"
+	"os"
+	"strings"
+	texttemplate "text/template"
 
 	"github.com/corbaltcode/kion/cmd/kion/config"
 	"github.com/corbaltcode/kion/cmd/kion/util"
-	"github.com/corbaltcode/kion/internal/client"
 	"github.com/pkg/browser"
 	"github.com/spf13/cobra"
 )
@@ -29,8 +31,13 @@ func New(cfg *config.Config, keyCfg *config.KeyConfig) *cobra.Command {
 	cmd.Flags().StringP("account-id", "", "", "AWS account ID")
 	cmd.Flags().StringP("cloud-access-role", "", "", "cloud access role")
 	cmd.Flags().BoolP("print", "p", false, "print URL instead of opening a browser")
+	cmd.Flags().BoolP("copy", "", false, "copy URL to clipboard (via OSC 52) instead of opening a browser")
+	cmd.Flags().BoolP("qr", "", false, "print URL as a QR code instead of opening a browser")
 	cmd.Flags().BoolP("logout", "", false, "log out of existing AWS console session")
 	cmd.Flags().StringP("session-duration", "", "1h", "duration of temporary credentials")
+	cmd.Flags().StringP("console-issuer", "", "https://{{.Host}}/login", "template for the URL visited when the console session expires")
+	cmd.Flags().BoolP("reauth-url", "", false, "serve a local endpoint that signs in again when the console session expires")
+	cmd.Flags().StringP("reauth-lifetime", "", "12h", "how long to serve the re-authentication endpoint")
 
 	return cmd
 }
@@ -49,6 +56,10 @@ func run(cfg *config.Config, keyCfg *config.KeyConfig) error {
 	if err != nil {
 		return err
 	}
+	issuerTemplate, err := texttemplate.New("console-issuer").Parse(cfg.String("console-issuer"))
+	if err != nil {
+		return fmt.Errorf("parsing console-issuer: %w", err)
+	}
 
 	kion, err := util.NewClient(cfg, keyCfg)
 	if err != nil {
@@ -60,54 +71,109 @@ func run(cfg *config.Config, keyCfg *config.KeyConfig) error {
 		return err
 	}
 
+	partition, err := accountInfo.Type.Partition()
+	if err != nil {
+		return err
+	}
 	var awsDomain string
-	switch accountInfo.Type {
-	case client.AccountTypeCommercial:
+	switch partition {
+	case "aws":
 		awsDomain = "aws.amazon.com"
-	case client.AccountTypeGovCloud:
+	case "aws-us-gov":
 		awsDomain = "amazonaws-us-gov.com"
 	default:
-		return errors.New(fmt.Sprintf("unexpected account type: %d", accountInfo.Type))
+		return fmt.Errorf("unsupported partition: %v", partition)
+	}
+
+	federate := func(issuer string) (string, error) {
+		creds, err := kion.GetTemporaryCredentialsByCloudAccessRole(accountID, cloudAccessRole)
+		if err != nil {
+			return "", err
+		}
+
+		signinToken, err := getAWSSigninToken(awsDomain, creds.AccessKeyID, creds.SecretAccessKey, creds.SessionToken)
+		if err != nil {
+			return "", err
+		}
+
+		v := url.Values{}
+		v.Add("Action", "login")
+		v.Add("Issuer", issuer)
+		v.Add("Destination", fmt.Sprintf("https://console.%s", awsDomain))
+		v.Add("SigninToken", signinToken)
+		return fmt.Sprintf("https://signin.%s/federation?", awsDomain) + v.Encode(), nil
+	}
+
+	if cfg.Bool("reauth-url") {
+		lifetime, err := cfg.DurationErr("reauth-lifetime")
+		if err != nil {
+			return err
+		}
+		return serveReauth(lifetime, federate, func(signinUrl string) error {
+			return open(cfg, signinUrl)
+		})
 	}
 
-	creds, err := kion.GetTemporaryCredentialsByCloudAccessRole(accountID, cloudAccessRole)
+	issuer := new(strings.Builder)
+	err = issuerTemplate.Execute(issuer, issuerData{
+		Host:            host,
+		AccountID:       accountID,
+		AccountName:     accountInfo.Name,
+		CloudAccessRole: cloudAccessRole,
+	})
 	if err != nil {
-		return err
+		return fmt.Errorf("executing console-issuer: %w", err)
 	}
 
-	signinToken, err := getAWSSigninToken(awsDomain, creds.AccessKeyID, creds.SecretAccessKey, creds.SessionToken)
+	signinUrl, err := federate(issuer.String())
 	if err != nil {
 		return err
 	}
 
-	v := url.Values{}
-	v.Add("Action", "login")
-	v.Add("Issuer", fmt.Sprintf("https://%s/login", host))
-	v.Add("Destination", fmt.Sprintf("https://console.%s", awsDomain))
-	v.Add("SigninToken", signinToken)
-	signinUrl := fmt.Sprintf("https://signin.%s/federation?", awsDomain) + v.Encode()
-
-	if cfg.Bool("print") {
-		fmt.Println(signinUrl)
-	} else if cfg.Bool("logout") {
-		html := new(bytes.Buffer)
-		err = logoutHtmlTemplate.Execute(html, signinUrl)
-		if err != nil {
-			return err
+	return open(cfg, signinUrl)
+}
+
+// issuerData is passed to the console-issuer template.
+type issuerData struct {
+	Host            string
+	AccountID       string
+	AccountName     string
+	CloudAccessRole string
+}
+
+// open prints, copies, or opens signinUrl according to the output flags.
+func open(cfg *config.Config, signinUrl string) error {
+	if cfg.Bool("print") || cfg.Bool("copy") || cfg.Bool("qr") {
+		if cfg.Bool("print") {
+			fmt.Println(signinUrl)
 		}
-		err = browser.OpenReader(html)
-		if err != nil {
-			return err
+		if cfg.Bool("copy") {
+			err := copyToClipboard(signinUrl)
+			if err != nil {
+				return err
+			}
+		}
+		if cfg.Bool("qr") {
+			err := printQRCode(os.Stdout, signinUrl)
+			if err != nil {
+				return err
+			}
 		}
-	} else {
-		err = browser.OpenURL(signinUrl)
+		return nil
+	}
+
+	if cfg.Bool("logout") {
+		html := new(bytes.Buffer)
+		err := logoutHtmlTemplate.Execute(html, signinUrl)
 		if err != nil {
 			return err
 		}
+		return browser.OpenReader(html)
 	}
 
-	return nil
+	return browser.OpenURL(signinUrl)
 }
+
 func getAWSSigninToken(awsDomain string, accessKeyID string, secretAccessKey string, sessionToken string) (string, error) {
 	session := map[string]string{
 		"sessionId":    accessKeyID,
@@ -0,0 +1,67 @@
+package console
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// serveReauth serves a local endpoint used as the console Issuer. AWS sends the
+// browser to the Issuer when the console session expires; the endpoint then
+// fetches fresh credentials and redirects back to the console. The endpoint is
+// served for lifetime or until the process is interrupted.
+//
+// federate returns a sign-in URL with the given Issuer, and open delivers the
+// initial sign-in URL to the user.
+func serveReauth(lifetime time.Duration, federate func(issuer string) (string, error), open func(signinUrl string) error) error {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+
+	// an unguessable path keeps other local pages from triggering sign-ins
+	secret := make([]byte, 16)
+	_, err = rand.Read(secret)
+	if err != nil {
+		return err
+	}
+	path := "/" + hex.EncodeToString(secret)
+	issuer := fmt.Sprintf("http://%s%s", listener.Addr(), path)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		signinUrl, err := federate(issuer)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "re-authentication failed: %v\n", err)
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		http.Redirect(w, r, signinUrl, http.StatusFound)
+	})
+	server := &http.Server{Handler: mux}
+	time.AfterFunc(lifetime, func() { server.Close() })
+
+	signinUrl, err := federate(issuer)
+	if err != nil {
+		listener.Close()
+		return err
+	}
+	err = open(signinUrl)
+	if err != nil {
+		listener.Close()
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Serving re-authentication endpoint until %v; press Ctrl-C to stop\n", time.Now().Add(lifetime).Format(time.Kitchen))
+
+	err = server.Serve(listener)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,71 @@
+package console
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+
+	"rsc.io/qr"
+)
+
+// copyToClipboard sets the terminal's clipboard using the OSC 52 escape
+// sequence. Because the sequence is interpreted by the terminal emulator rather
+// than the host, this works over SSH. Not all terminals support OSC 52.
+func copyToClipboard(s string) error {
+	// write to the controlling terminal so the sequence isn't captured when
+	// stdout is redirected
+	tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0)
+	if err != nil {
+		tty = os.Stderr
+	} else {
+		defer tty.Close()
+	}
+
+	seq := fmt.Sprintf("\x1b]52;c;%s\a", base64.StdEncoding.EncodeToString([]byte(s)))
+
+	// tmux passes escape sequences through to the outer terminal only when
+	// wrapped in a DCS sequence
+	if os.Getenv("TMUX") != "" {
+		seq = "\x1bPtmux;\x1b" + seq + "\x1b\\"
+	}
+
+	_, err = io.WriteString(tty, seq)
+	return err
+}
+
+// printQRCode writes s to w as a QR code drawn with Unicode block characters.
+// Each line of output holds two rows of modules. The code is drawn in black on
+// white, set with ANSI escape sequences, so that it scans the same whatever the
+// terminal's colors.
+func printQRCode(w io.Writer, s string) error {
+	code, err := qr.Encode(s, qr.L)
+	if err != nil {
+		return err
+	}
+
+	// the QR spec calls for a four-module quiet zone; Black is false outside
+	// the code
+	const quietZone = 4
+
+	bw := bufio.NewWriter(w)
+	for y := -quietZone; y < code.Size+quietZone; y += 2 {
+		bw.WriteString("\x1b[30;107m")
+		for x := -quietZone; x < code.Size+quietZone; x++ {
+			top, bottom := code.Black(x, y), code.Black(x, y+1)
+			switch {
+			case top && bottom:
+				bw.WriteString("█")
+			case top:
+				bw.WriteString("▀")
+			case bottom:
+				bw.WriteString("▄")
+			default:
+				bw.WriteString(" ")
+			}
+		}
+		bw.WriteString("\x1b[0m\n")
+	}
+	return bw.Flush()
+}
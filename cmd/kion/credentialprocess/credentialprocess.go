@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
@@ -34,7 +35,12 @@ func New(cfg *config.Config, keyCfg *config.KeyConfig) *cobra.Command {
 }
 
 func run(cfg *config.Config, keyCfg *config.KeyConfig) error {
-	userConfigDir, err := config.UserConfigDir()
+	// the AWS CLI may show anything written to stderr, so stay quiet, and
+	// never prompt since stdin isn't the user's
+	util.Warnings = io.Discard
+	util.Interactive = false
+
+	cacheName, err := CacheName()
 	if err != nil {
 		return err
 	}
@@ -63,8 +69,6 @@ func run(cfg *config.Config, keyCfg *config.KeyConfig) error {
 		return err
 	}
 
-	cacheName := filepath.Join(userConfigDir, "credential_process_cache.yml")
-
 	credsWithExpiry, err := readCachedCredentials(cacheName, host, idms, username, accountID, cloudAccessRole)
 	if err != nil {
 		return err
@@ -103,6 +107,35 @@ func run(cfg *config.Config, keyCfg *config.KeyConfig) error {
 	return json.NewEncoder(os.Stdout).Encode(out)
 }
 
+// CacheName returns the name of the credential cache file.
+func CacheName() (string, error) {
+	userCacheDir, err := config.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(userCacheDir, "credential_process_cache.yml"), nil
+}
+
+// CacheStatus reads the credential cache, returning the number of cached
+// credentials and the number of those that have expired. A missing cache is
+// empty.
+func CacheStatus() (entries int, expired int, err error) {
+	cacheName, err := CacheName()
+	if err != nil {
+		return 0, 0, err
+	}
+	cache, err := loadCache(cacheName)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, creds := range cache {
+		if !time.Now().Before(creds.Expiry) {
+			expired++
+		}
+	}
+	return len(cache), expired, nil
+}
+
 type credentialsWithExpiry struct {
 	Credentials client.TemporaryCredentials
 	Expiry      time.Time
@@ -127,6 +160,10 @@ func writeCachedCredentials(cacheName string, host string, idms int, username st
 		return err
 	}
 	cache[cacheKey(host, idms, username, accountID, cloudAccessRole)] = *creds
+	err = os.MkdirAll(filepath.Dir(cacheName), 0700)
+	if err != nil {
+		return err
+	}
 	f, err := os.OpenFile(cacheName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		return err
@@ -0,0 +1,241 @@
+package credentials
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/corbaltcode/kion/cmd/kion/config"
+	"github.com/corbaltcode/kion/cmd/kion/util"
+	"github.com/corbaltcode/kion/internal/client"
+)
+
+// target is an account and cloud access role to fetch credentials for.
+// AccountName is empty for accounts read from a file.
+type target struct {
+	AccountID       string
+	AccountName     string
+	CloudAccessRole string
+}
+
+type result struct {
+	target
+	creds *client.TemporaryCredentials
+	err   error
+}
+
+// batchCredentials is the json format of a result.
+type batchCredentials struct {
+	AccountID       string `json:"account_id"`
+	AccountName     string `json:"account_name,omitempty"`
+	CloudAccessRole string `json:"cloud_access_role"`
+	*client.TemporaryCredentials
+	Error string `json:"error,omitempty"`
+}
+
+// runBatch fetches credentials for many accounts with a bounded number of
+// concurrent requests. A failure for one account is reported without stopping
+// the others.
+func runBatch(cfg *config.Config, keyCfg *config.KeyConfig) error {
+	accountsFile := cfg.String("accounts-file")
+	if cfg.Bool("all") && accountsFile != "" {
+		return errors.New("--all and --accounts-file can't be used together")
+	}
+	if cfg.Bool("verify") {
+		return errors.New("--verify can't be used with --all or --accounts-file")
+	}
+	format, err := cfg.StringErr("format")
+	if err != nil {
+		return err
+	}
+	if format != "aws" && format != "json" {
+		return fmt.Errorf("invalid format with --all or --accounts-file: %v (want aws or json)", format)
+	}
+	parallel, err := cfg.IntErr("parallel")
+	if err != nil {
+		return err
+	}
+	if parallel < 1 {
+		return fmt.Errorf("invalid parallel: %v", parallel)
+	}
+	profileTemplate, err := template.New("profile-template").Parse(cfg.String("profile-template"))
+	if err != nil {
+		return fmt.Errorf("parsing profile-template: %w", err)
+	}
+
+	kion, err := util.NewClient(cfg, keyCfg)
+	if err != nil {
+		return err
+	}
+
+	var targets []target
+	if accountsFile != "" {
+		targets, err = readAccountsFile(accountsFile, cfg.String("cloud-access-role"))
+	} else {
+		targets, err = allTargets(kion, cfg.String("cloud-access-role"))
+	}
+	if err != nil {
+		return err
+	}
+
+	results := fetch(kion, targets, parallel)
+
+	failed := 0
+	for _, r := range results {
+		if r.err != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, "error: %v (%v): %v\n", r.AccountID, r.CloudAccessRole, r.err)
+		}
+	}
+
+	switch format {
+	case "aws":
+		err = printProfiles(results, profileTemplate)
+	case "json":
+		err = printJSONLines(results)
+	default:
+		panic(fmt.Sprintf("unexpected format: %v", format))
+	}
+	if err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to fetch credentials for %v of %v accounts", failed, len(results))
+	}
+	return nil
+}
+
+// allTargets returns every account and cloud access role the user can access,
+// limited to role if it isn't empty.
+func allTargets(kion *client.Client, role string) ([]target, error) {
+	acars, err := kion.GetAccountCloudAccessRoles()
+	if err != nil {
+		return nil, err
+	}
+
+	targets := []target{}
+	for _, acar := range acars {
+		if role != "" && role != acar.CloudAccessRole {
+			continue
+		}
+		targets = append(targets, target{
+			AccountID:       acar.AccountID,
+			AccountName:     acar.AccountName,
+			CloudAccessRole: acar.CloudAccessRole,
+		})
+	}
+	return targets, nil
+}
+
+// readAccountsFile reads targets from a file with one account ID and optional
+// cloud access role per line. defaultRole is used for lines without a role.
+// Blank lines and lines starting with # are ignored.
+func readAccountsFile(name string, defaultRole string) ([]target, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	targets := []target{}
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		t := target{AccountID: fields[0], CloudAccessRole: defaultRole}
+		switch len(fields) {
+		case 1:
+			if defaultRole == "" {
+				return nil, fmt.Errorf("%v:%v: no cloud access role (set one on the line or with --cloud-access-role)", name, lineNum)
+			}
+		case 2:
+			t.CloudAccessRole = fields[1]
+		default:
+			return nil, fmt.Errorf("%v:%v: want \"account-id [cloud-access-role]\"", name, lineNum)
+		}
+		targets = append(targets, t)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return targets, nil
+}
+
+// fetch fetches credentials for each target using up to parallel concurrent
+// requests. Results are in the same order as targets.
+func fetch(kion *client.Client, targets []target, parallel int) []result {
+	results := make([]result, len(targets))
+	indexes := make(chan int)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < parallel && i < len(targets); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				t := targets[i]
+				creds, err := kion.GetTemporaryCredentialsByCloudAccessRole(t.AccountID, t.CloudAccessRole)
+				results[i] = result{target: t, creds: creds, err: err}
+			}
+		}()
+	}
+	for i := range targets {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	return results
+}
+
+// printProfiles prints successful results as profiles of an AWS credentials
+// file, named by profileTemplate.
+func printProfiles(results []result, profileTemplate *template.Template) error {
+	for _, r := range results {
+		if r.err != nil {
+			continue
+		}
+		profile := new(strings.Builder)
+		err := profileTemplate.Execute(profile, r.target)
+		if err != nil {
+			return fmt.Errorf("executing profile-template: %w", err)
+		}
+
+		fmt.Printf("[%v]\n", profile)
+		fmt.Printf("aws_access_key_id = %v\n", r.creds.AccessKeyID)
+		fmt.Printf("aws_secret_access_key = %v\n", r.creds.SecretAccessKey)
+		fmt.Printf("aws_session_token = %v\n", r.creds.SessionToken)
+		fmt.Println()
+	}
+	return nil
+}
+
+// printJSONLines prints each result, including failures, as a line of JSON.
+func printJSONLines(results []result) error {
+	enc := json.NewEncoder(os.Stdout)
+	for _, r := range results {
+		out := batchCredentials{
+			AccountID:            r.AccountID,
+			AccountName:          r.AccountName,
+			CloudAccessRole:      r.CloudAccessRole,
+			TemporaryCredentials: r.creds,
+		}
+		if r.err != nil {
+			out.Error = r.err.Error()
+		}
+		err := enc.Encode(out)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
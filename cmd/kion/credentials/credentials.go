@@ -15,7 +15,11 @@ func New(cfg *config.Config, keyCfg *config.KeyConfig) *cobra.Command {
 		Use:     "credentials",
 		Aliases: []string{"creds"},
 		Short:   "Prints temporary credentials",
-		Args:    cobra.NoArgs,
+		Long: `Prints temporary credentials
+
+With --all or --accounts-file, credentials are fetched for many accounts at once
+(see README).`,
+		Args: cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return run(cfg, keyCfg)
 		},
@@ -24,11 +28,20 @@ func New(cfg *config.Config, keyCfg *config.KeyConfig) *cobra.Command {
 	cmd.Flags().StringP("account-id", "", "", "AWS account ID")
 	cmd.Flags().StringP("cloud-access-role", "", "", "cloud access role")
 	cmd.Flags().StringP("format", "f", "aws", "format (aws, export, or json)")
+	cmd.Flags().BoolP("verify", "", false, "check the credentials with STS GetCallerIdentity before printing them")
+	cmd.Flags().BoolP("all", "", false, "fetch credentials for every account and role you can access (limited to --cloud-access-role if set)")
+	cmd.Flags().StringP("accounts-file", "", "", "fetch credentials for the accounts listed in a file, one \"account-id [cloud-access-role]\" per line")
+	cmd.Flags().IntP("parallel", "", 8, "number of accounts to fetch credentials for at once with --all or --accounts-file")
+	cmd.Flags().StringP("profile-template", "", "{{.AccountID}}-{{.CloudAccessRole}}", "template for profile names in the aws format with --all or --accounts-file")
 
 	return cmd
 }
 
 func run(cfg *config.Config, keyCfg *config.KeyConfig) error {
+	if cfg.Bool("all") || cfg.String("accounts-file") != "" {
+		return runBatch(cfg, keyCfg)
+	}
+
 	accountID, err := cfg.StringErr("account-id")
 	if err != nil {
 		return err
@@ -53,6 +66,14 @@ func run(cfg *config.Config, keyCfg *config.KeyConfig) error {
 	if err != nil {
 		return err
 	}
+	if cfg.Bool("verify") {
+		id, err := util.VerifyCredentials(kion, accountID, creds)
+		if err != nil {
+			return err
+		}
+		// stdout is reserved for the credentials
+		fmt.Fprintf(os.Stderr, "verified: %v\n", id.Arn)
+	}
 
 	switch format {
 	case "aws":
@@ -0,0 +1,352 @@
+package doctor
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/corbaltcode/kion/cmd/kion/config"
+	"github.com/corbaltcode/kion/cmd/kion/credentialprocess"
+	"github.com/corbaltcode/kion/cmd/kion/util"
+	"github.com/corbaltcode/kion/internal/client"
+	"github.com/spf13/cobra"
+	"github.com/zalando/go-keyring"
+)
+
+func New(cfg *config.Config, keyCfg *config.KeyConfig) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Diagnoses configuration and connectivity problems",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return run(cfg, keyCfg)
+		},
+	}
+
+	cmd.Flags().StringP("format", "f", "text", "format (text or json)")
+
+	return cmd
+}
+
+type status string
+
+const (
+	statusPass status = "pass"
+	statusWarn status = "warn"
+	statusFail status = "fail"
+)
+
+type result struct {
+	Check   string `json:"check"`
+	Status  status `json:"status"`
+	Message string `json:"message"`
+}
+
+type report struct {
+	results []result
+}
+
+func (r *report) add(check string, s status, format string, a ...interface{}) {
+	r.results = append(r.results, result{Check: check, Status: s, Message: fmt.Sprintf(format, a...)})
+}
+
+func run(cfg *config.Config, keyCfg *config.KeyConfig) error {
+	format, err := cfg.StringErr("format")
+	if err != nil {
+		return err
+	}
+	if format != "text" && format != "json" {
+		return fmt.Errorf("invalid format: %v", format)
+	}
+
+	r := &report{}
+	checkConfig(r, cfg)
+	// a key that can't be read is reported below; carry on as if there were none
+	keyErr := util.LoadKeyConfig(cfg, keyCfg)
+	host := cfg.String("host")
+	if host == "" {
+		r.add("host", statusFail, "missing config value: host")
+	} else {
+		checkHost(r, host)
+		checkIDMS(r, cfg, host, keyCfg.Key != "")
+	}
+	checkKeyring(r, cfg, keyCfg.Key != "")
+	if keyErr != nil {
+		r.add("app-api-key", statusFail, "%v", keyErr)
+	} else {
+		checkAppAPIKey(r, cfg, keyCfg)
+	}
+	checkCache(r)
+	if host != "" {
+		checkAPI(r, cfg, keyCfg)
+	}
+
+	failed := false
+	for _, res := range r.results {
+		if res.Status == statusFail {
+			failed = true
+		}
+	}
+
+	switch format {
+	case "text":
+		for _, res := range r.results {
+			fmt.Printf("[%v] %v: %v\n", res.Status, res.Check, res.Message)
+		}
+	case "json":
+		err = json.NewEncoder(os.Stdout).Encode(r.results)
+		if err != nil {
+			return err
+		}
+	default:
+		panic(fmt.Sprintf("unexpected format: %v", format))
+	}
+
+	if failed {
+		return errors.New("one or more checks failed")
+	}
+	return nil
+}
+
+// checkConfig checks that each config file parses and reports each setting with
+// its source.
+func checkConfig(r *report, cfg *config.Config) {
+	layers, err := config.Layers()
+	if err != nil {
+		r.add("config", statusFail, "%v", err)
+		return
+	}
+
+	for _, layer := range layers {
+		_, err := layer.Load()
+		if errors.Is(err, fs.ErrNotExist) {
+			r.add("config", statusPass, "%v: not present", layer.Path)
+			continue
+		} else if errors.Is(err, config.ErrUntrusted) {
+			r.add("config", statusWarn, "%v: skipped: %v", layer.Path, err)
+			continue
+		} else if err != nil {
+			r.add("config", statusFail, "%v: %v", layer.Path, err)
+			continue
+		}
+		if layer.Path != "" {
+			r.add("config", statusPass, "%v: parsed", layer.Path)
+		}
+		problems, err := layer.Validate()
+		if err != nil {
+			r.add("config", statusFail, "%v: %v", layer, err)
+		}
+		for _, p := range problems {
+			if p.Warning {
+				r.add("config", statusWarn, "%v", p)
+			} else {
+				r.add("config", statusFail, "%v", p)
+			}
+		}
+	}
+
+	for _, key := range cfg.SetKeys() {
+		r.add("config", statusPass, "%v = %v (from %v)", key, shorten(cfg.String(key)), cfg.Sources[key])
+	}
+}
+
+// checkHost checks that host accepts TLS connections and that its certificate
+// isn't near expiry.
+func checkHost(r *report, host string) {
+	addr := host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		addr = net.JoinHostPort(host, "443")
+	}
+
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	conn, err := tls.DialWithDialer(dialer, "tcp", addr, nil)
+	if err != nil {
+		r.add("host", statusFail, "%v: %v", addr, err)
+		return
+	}
+	defer conn.Close()
+
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		r.add("host", statusFail, "%v: no TLS certificate", addr)
+		return
+	}
+	remaining := time.Until(certs[0].NotAfter)
+	if remaining < 14*24*time.Hour {
+		r.add("host", statusWarn, "%v: TLS certificate expires %v", addr, certs[0].NotAfter.Format(time.RFC3339))
+		return
+	}
+	r.add("host", statusPass, "%v: reachable; TLS certificate valid until %v", addr, certs[0].NotAfter.Format(time.RFC3339))
+}
+
+func checkIDMS(r *report, cfg *config.Config, host string, usingAppAPIKey bool) {
+	id := cfg.Int("idms")
+	if id == 0 {
+		if usingAppAPIKey {
+			r.add("idms", statusPass, "not configured (not needed with an App API Key)")
+		} else {
+			r.add("idms", statusFail, "missing config value: idms")
+		}
+		return
+	}
+
+	idmss, err := client.GetIDMSs(host)
+	if err != nil {
+		r.add("idms", statusFail, "listing IDMSs: %v", err)
+		return
+	}
+	for _, idms := range idmss {
+		if idms.ID == id {
+			r.add("idms", statusPass, "%v (%v)", idms.Name, idms.ID)
+			return
+		}
+	}
+	r.add("idms", statusFail, "no IDMS with ID %v", id)
+}
+
+func checkKeyring(r *report, cfg *config.Config, usingAppAPIKey bool) {
+	host := cfg.String("host")
+	idms := cfg.Int("idms")
+	username := cfg.String("username")
+
+	if host == "" || idms == 0 || username == "" {
+		// probe with an entry that won't exist
+		_, err := keyring.Get("kion-doctor", "probe")
+		if err != nil && !errors.Is(err, keyring.ErrNotFound) {
+			r.add("keyring", statusWarn, "unavailable: %v", err)
+			return
+		}
+		r.add("keyring", statusPass, "available")
+		return
+	}
+
+	if cfg.String("password-command") != "" {
+		r.add("keyring", statusPass, "not used; password-command supplies the password")
+		return
+	}
+	storage := cfg.String("password-storage")
+	if storage == util.PasswordStorageFile && os.Getenv("KION_SECRETS_PASSPHRASE") == "" {
+		r.add("keyring", statusPass, "not used; password is in the secrets file (set KION_SECRETS_PASSPHRASE to check it)")
+		return
+	}
+
+	_, err := util.GetSecret(storage, util.KeyringService(host, idms), username)
+	switch {
+	case err == nil:
+		r.add("keyring", statusPass, "user credentials stored for %v", username)
+	case errors.Is(err, keyring.ErrNotFound) && usingAppAPIKey:
+		r.add("keyring", statusPass, "available; no user credentials stored (not needed with an App API Key)")
+	case errors.Is(err, keyring.ErrNotFound):
+		r.add("keyring", statusFail, "no user credentials stored for %v; run \"kion login\"", username)
+	case usingAppAPIKey:
+		r.add("keyring", statusWarn, "unavailable: %v", err)
+	default:
+		r.add("keyring", statusFail, "unavailable: %v", err)
+	}
+}
+
+func checkAppAPIKey(r *report, cfg *config.Config, keyCfg *config.KeyConfig) {
+	if keyCfg.Key == "" {
+		r.add("app-api-key", statusPass, "not configured; using user credentials")
+		return
+	}
+
+	expiry, err := util.AppAPIKeyExpiry(cfg, keyCfg)
+	if err != nil {
+		r.add("app-api-key", statusFail, "%v", err)
+		return
+	}
+	remaining := time.Until(expiry).Round(time.Minute)
+	rotate := cfg.Bool("rotate-app-api-keys")
+
+	switch {
+	case remaining <= 0:
+		r.add("app-api-key", statusFail, "expired %v; run \"kion key create --force\"", expiry.Format(time.RFC3339))
+	case remaining < util.RotationWindow && rotate:
+		r.add("app-api-key", statusPass, "expires in %v; rotation due on next use", remaining)
+	case remaining < util.RotationWindow:
+		r.add("app-api-key", statusWarn, "expires in %v and automatic rotation is off; run \"kion key rotate\"", remaining)
+	case rotate:
+		r.add("app-api-key", statusPass, "expires in %v; rotation due in %v", remaining, remaining-util.RotationWindow)
+	default:
+		r.add("app-api-key", statusPass, "expires in %v; automatic rotation is off", remaining)
+	}
+}
+
+func checkCache(r *report) {
+	cacheName, err := credentialprocess.CacheName()
+	if err != nil {
+		r.add("cache", statusFail, "%v", err)
+		return
+	}
+	info, err := os.Stat(cacheName)
+	if errors.Is(err, fs.ErrNotExist) {
+		r.add("cache", statusPass, "%v: not present", cacheName)
+		return
+	} else if err != nil {
+		r.add("cache", statusFail, "%v", err)
+		return
+	}
+
+	entries, expired, err := credentialprocess.CacheStatus()
+	if err != nil {
+		r.add("cache", statusFail, "%v; delete %v to reset", err, cacheName)
+		return
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		r.add("cache", statusWarn, "%v: readable by other users (mode %v)", cacheName, info.Mode().Perm())
+		return
+	}
+	r.add("cache", statusPass, "%v: %v entries (%v expired)", cacheName, entries, expired)
+}
+
+func checkAPI(r *report, cfg *config.Config, keyCfg *config.KeyConfig) {
+	// doctor only observes: NewClient would complete a pending key, so report
+	// it instead
+	if keyCfg.PendingKey != "" {
+		r.add("api", statusWarn, "App API Key %v is pending (a creation or rotation was interrupted); not checking the API; run any command that uses Kion to complete it", keyCfg.PendingID)
+		return
+	}
+
+	// nor may the client rotate or recreate the key, or prompt
+	for _, key := range []string{"rotate-app-api-keys", "auto-recreate-app-api-key"} {
+		err := cfg.Set(key, false)
+		if err != nil {
+			r.add("api", statusFail, "%v", err)
+			return
+		}
+	}
+	util.Interactive = false
+
+	kion, err := util.NewClient(cfg, keyCfg)
+	if err != nil {
+		r.add("api", statusFail, "creating client: %v", err)
+		return
+	}
+	acars, err := kion.GetAccountCloudAccessRoles()
+	if err != nil {
+		r.add("api", statusFail, "listing cloud access roles: %v", err)
+		return
+	}
+
+	accounts := map[string]bool{}
+	for _, acar := range acars {
+		accounts[acar.AccountID] = true
+	}
+	r.add("api", statusPass, "authenticated; %v cloud access roles across %v accounts", len(acars), len(accounts))
+}
+
+// shorten keeps long values (e.g. templates) readable in the report.
+func shorten(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) > 60 {
+		return s[:57] + "..."
+	}
+	return s
+}
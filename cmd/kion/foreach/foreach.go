@@ -0,0 +1,190 @@
+package foreach
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"sync"
+
+	"github.com/corbaltcode/kion/cmd/kion/config"
+	"github.com/corbaltcode/kion/cmd/kion/util"
+	"github.com/corbaltcode/kion/internal/client"
+	"github.com/spf13/cobra"
+)
+
+func New(cfg *config.Config, keyCfg *config.KeyConfig) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "foreach [flags] -- command [args...]",
+		Short: "Runs a command in each matching account",
+		Long: `Runs a command in each matching account
+
+The command is run once for each account you can access with the cloud access
+role, with temporary credentials in AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY,
+and AWS_SESSION_TOKEN, and the account in KION_ACCOUNT_ID and
+KION_ACCOUNT_NAME. Each line of output is prefixed with the account name.
+
+--account and --account-id accept glob patterns (e.g. 'prod-*').`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// the patterns are read from the flags only, since account and
+			// account-id in a config file would otherwise become filters
+			accountPattern, err := cmd.Flags().GetString("account")
+			if err != nil {
+				return err
+			}
+			accountIDPattern, err := cmd.Flags().GetString("account-id")
+			if err != nil {
+				return err
+			}
+			return run(cfg, keyCfg, accountPattern, accountIDPattern, args)
+		},
+	}
+
+	// flags after the command belong to the command
+	cmd.Flags().SetInterspersed(false)
+
+	cmd.Flags().StringP("account", "", "", "account name pattern")
+	cmd.Flags().StringP("account-id", "", "", "account ID pattern")
+	cmd.Flags().StringP("cloud-access-role", "r", "", "cloud access role")
+	cmd.Flags().IntP("parallel", "p", 1, "number of accounts to run the command in at once")
+
+	return cmd
+}
+
+// outcome is the result of running the command in an account.
+type outcome struct {
+	account  client.AccountCloudAccessRole
+	exitCode int
+	err      error
+}
+
+func run(cfg *config.Config, keyCfg *config.KeyConfig, accountPattern string, accountIDPattern string, args []string) error {
+	role, err := cfg.StringErr("cloud-access-role")
+	if err != nil {
+		return err
+	}
+	parallel, err := cfg.IntErr("parallel")
+	if err != nil {
+		return err
+	}
+	if parallel < 1 {
+		return fmt.Errorf("invalid parallel: %v", parallel)
+	}
+	// check the patterns before doing anything
+	for _, pattern := range []string{accountPattern, accountIDPattern} {
+		_, err := path.Match(pattern, "")
+		if err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+
+	kion, err := util.NewClient(cfg, keyCfg)
+	if err != nil {
+		return err
+	}
+
+	acars, err := kion.GetAccountCloudAccessRoles()
+	if err != nil {
+		return err
+	}
+	accounts := []client.AccountCloudAccessRole{}
+	for _, acar := range acars {
+		if acar.CloudAccessRole != role {
+			continue
+		}
+		if !matches(accountPattern, acar.AccountName) || !matches(accountIDPattern, acar.AccountID) {
+			continue
+		}
+		accounts = append(accounts, acar)
+	}
+	if len(accounts) == 0 {
+		return errors.New("no matching accounts")
+	}
+
+	outcomes := make([]outcome, len(accounts))
+	indexes := make(chan int)
+	mu := &sync.Mutex{}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < parallel && i < len(accounts); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				exitCode, err := runIn(kion, accounts[i], args, mu)
+				outcomes[i] = outcome{account: accounts[i], exitCode: exitCode, err: err}
+			}
+		}()
+	}
+	for i := range accounts {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	failed := 0
+	fmt.Fprintln(os.Stderr)
+	for _, o := range outcomes {
+		switch {
+		case o.err != nil:
+			failed++
+			fmt.Fprintf(os.Stderr, "%v (%v): error: %v\n", o.account.AccountName, o.account.AccountID, o.err)
+		case o.exitCode != 0:
+			failed++
+			fmt.Fprintf(os.Stderr, "%v (%v): exit status %v\n", o.account.AccountName, o.account.AccountID, o.exitCode)
+		default:
+			fmt.Fprintf(os.Stderr, "%v (%v): ok\n", o.account.AccountName, o.account.AccountID)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("command failed in %v of %v accounts", failed, len(outcomes))
+	}
+	return nil
+}
+
+// matches reports whether name matches the glob pattern. An empty pattern
+// matches everything.
+func matches(pattern string, name string) bool {
+	if pattern == "" {
+		return true
+	}
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
+
+// runIn runs the command with credentials for the account, returning its exit
+// code. Output lines are prefixed with the account name; mu serializes output
+// from concurrent commands.
+func runIn(kion *client.Client, account client.AccountCloudAccessRole, args []string, mu *sync.Mutex) (int, error) {
+	creds, err := kion.GetTemporaryCredentialsByCloudAccessRole(account.AccountID, account.CloudAccessRole)
+	if err != nil {
+		return 0, err
+	}
+
+	prefix := fmt.Sprintf("[%v] ", account.AccountName)
+	stdout := &prefixWriter{mu: mu, w: os.Stdout, prefix: prefix}
+	stderr := &prefixWriter{mu: mu, w: os.Stderr, prefix: prefix}
+	defer stdout.Flush()
+	defer stderr.Flush()
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.Env = append(os.Environ(),
+		"AWS_ACCESS_KEY_ID="+creds.AccessKeyID,
+		"AWS_SECRET_ACCESS_KEY="+creds.SecretAccessKey,
+		"AWS_SESSION_TOKEN="+creds.SessionToken,
+		"KION_ACCOUNT_ID="+account.AccountID,
+		"KION_ACCOUNT_NAME="+account.AccountName,
+	)
+
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+	return 0, err
+}
@@ -0,0 +1,79 @@
+package foreach
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestPrefixWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		want   string
+	}{
+		{"one line", []string{"a\n"}, "p: a\n"},
+		{"several lines in one write", []string{"a\nb\nc\n"}, "p: a\np: b\np: c\n"},
+		{"line split across writes", []string{"a", "b\n"}, "p: ab\n"},
+		{"partial last line", []string{"a\nb"}, "p: a\np: b\n"},
+		{"empty line", []string{"\n"}, "p: \n"},
+		{"nothing", nil, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out := new(bytes.Buffer)
+			p := &prefixWriter{mu: &sync.Mutex{}, w: out, prefix: "p: "}
+			for _, w := range test.writes {
+				n, err := p.Write([]byte(w))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if n != len(w) {
+					t.Fatalf("wrote %v bytes (want %v)", n, len(w))
+				}
+			}
+			err := p.Flush()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != test.want {
+				t.Errorf("got %q (want %q)", out.String(), test.want)
+			}
+		})
+	}
+}
+
+func TestPrefixWriterConcurrent(t *testing.T) {
+	out := new(bytes.Buffer)
+	mu := &sync.Mutex{}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			p := &prefixWriter{mu: mu, w: out, prefix: fmt.Sprintf("%v: ", i)}
+			for j := 0; j < 100; j++ {
+				// split lines across writes to catch interleaving
+				fmt.Fprintf(p, "line %v", j)
+				fmt.Fprint(p, " end\n")
+			}
+			p.Flush()
+		}(i)
+	}
+	wg.Wait()
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 400 {
+		t.Fatalf("got %v lines (want 400)", len(lines))
+	}
+	for _, line := range lines {
+		var i, j int
+		_, err := fmt.Sscanf(line, "%d: line %d end", &i, &j)
+		if err != nil || line != fmt.Sprintf("%v: line %v end", i, j) {
+			t.Fatalf("interleaved line: %q", line)
+		}
+	}
+}
@@ -3,6 +3,10 @@ package key
 import (
 	"errors"
 	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/AlecAivazis/survey/v2"
@@ -10,7 +14,6 @@ import (
 	"github.com/corbaltcode/kion/cmd/kion/util"
 	"github.com/corbaltcode/kion/internal/client"
 	"github.com/spf13/cobra"
-	"github.com/zalando/go-keyring"
 )
 
 func New(cfg *config.Config, keyCfg *config.KeyConfig) *cobra.Command {
@@ -29,6 +32,7 @@ func New(cfg *config.Config, keyCfg *config.KeyConfig) *cobra.Command {
 		},
 	}
 	createCmd.Flags().BoolP("force", "f", false, "overwrite existing key")
+	createCmd.Flags().StringP("name", "", "", "key name or name template (default app-api-key-name)")
 
 	rotateCmd := &cobra.Command{
 		Use:   "rotate",
@@ -39,13 +43,60 @@ func New(cfg *config.Config, keyCfg *config.KeyConfig) *cobra.Command {
 		},
 	}
 
+	statusCmd := &cobra.Command{
+		Use:   "status",
+		Short: "Prints App API Key details and expiry",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runStatus(cfg, keyCfg)
+		},
+	}
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "Lists your App API Keys",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runList(cfg, keyCfg)
+		},
+	}
+
+	revokeCmd := &cobra.Command{
+		Use:   "revoke <id>",
+		Short: "Deletes an App API Key",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runRevoke(cfg, keyCfg, args[0])
+		},
+	}
+	revokeCmd.Flags().BoolP("yes", "y", false, "don't ask for confirmation")
+
+	pruneCmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Deletes App API Keys this config used before the current key",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runPrune(cfg, keyCfg)
+		},
+	}
+	pruneCmd.Flags().BoolP("yes", "y", false, "don't ask for confirmation")
+	pruneCmd.Flags().BoolP("all-hosts", "", false, "also revoke keys named by this tool that this config didn't use, which may be in use on other hosts")
+
 	cmd.AddCommand(createCmd)
 	cmd.AddCommand(rotateCmd)
+	cmd.AddCommand(statusCmd)
+	cmd.AddCommand(listCmd)
+	cmd.AddCommand(revokeCmd)
+	cmd.AddCommand(pruneCmd)
 
 	return cmd
 }
 
 func runCreate(cfg *config.Config, keyCfg *config.KeyConfig) error {
+	err := util.LoadKeyConfig(cfg, keyCfg)
+	if err != nil {
+		return err
+	}
 	if keyCfg.Key != "" && !cfg.Bool("force") {
 		return errors.New("key exists; use --force to overwrite")
 	}
@@ -54,72 +105,288 @@ func runCreate(cfg *config.Config, keyCfg *config.KeyConfig) error {
 	if err != nil {
 		return err
 	}
-	idms, err := cfg.IntErr("idms")
+
+	kion, err := util.UserClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	nameTemplate := cfg.String("app-api-key-name")
+	if cfg.String("name") != "" {
+		nameTemplate = cfg.String("name")
+	}
+	name, err := util.RenderAppAPIKeyName(nameTemplate, cfg.String("username"))
+	if err != nil {
+		return err
+	}
+
+	unlock, err := util.LockAndReloadKeyConfig(keyCfg)
 	if err != nil {
 		return err
 	}
-	username, err := cfg.StringErr("username")
+	defer unlock()
+	// another process may have saved a key while we logged in
+	if keyCfg.Key != "" && !cfg.Bool("force") {
+		return errors.New("key exists; use --force to overwrite")
+	}
+
+	key, err := kion.CreateAppAPIKey(name)
 	if err != nil {
 		return err
 	}
 
-	password, err := keyring.Get(util.KeyringService(host, idms), username)
-	if errors.Is(err, keyring.ErrNotFound) {
-		err = survey.AskOne(
-			&survey.Password{Message: fmt.Sprintf("Password for '%v' on '%v' (IDMS %v):", username, host, idms)},
-			&password,
-			survey.WithValidator(survey.Required),
-		)
+	return util.SaveAppAPIKey(keyCfg, host, key)
+}
+
+func runRotate(cfg *config.Config, keyCfg *config.KeyConfig) error {
+	err := util.LoadKeyConfig(cfg, keyCfg)
+	if err != nil {
+		return err
+	}
+	host, err := cfg.StringErr("host")
+	if err != nil {
+		return err
+	}
+	err = util.CheckAppAPIKeyHost(keyCfg, host)
+	if err != nil {
+		return err
+	}
+	if keyCfg.PendingKey != "" {
+		err = util.CompletePendingAppAPIKey(keyCfg)
 		if err != nil {
 			return err
 		}
-	} else if err != nil {
+	}
+
+	return util.RotateAppAPIKey(cfg, keyCfg, true)
+}
+
+func runStatus(cfg *config.Config, keyCfg *config.KeyConfig) error {
+	err := util.LoadKeyConfig(cfg, keyCfg)
+	if err != nil {
+		return err
+	}
+	if keyCfg.Key == "" {
+		return errors.New("no App API Key; run \"kion key create\" to create one")
+	}
+	host, err := cfg.StringErr("host")
+	if err != nil {
+		return err
+	}
+	err = util.CheckAppAPIKeyHost(keyCfg, host)
+	if err != nil {
 		return err
 	}
 
-	kion, err := client.Login(host, idms, username, password)
+	// refresh the creation time and expiry from Kion if the key still works
+	if keyCfg.ID != 0 {
+		expiry, err := util.AppAPIKeyExpiry(cfg, keyCfg)
+		if err != nil {
+			return err
+		}
+		if time.Now().Before(expiry) {
+			kion := client.NewWithAppAPIKey(host, keyCfg.Key, expiry)
+			keyMetadata, err := kion.GetAppAPIKeyMetadata(keyCfg.ID)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "warning: getting key metadata: %v\n", err)
+			} else if !keyMetadata.Created.Equal(keyCfg.Created) || !keyMetadata.Expiry.Equal(keyCfg.Expiry) {
+				keyCfg.Created = keyMetadata.Created
+				keyCfg.Expiry = keyMetadata.Expiry
+				err = keyCfg.Save()
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	expiry, err := util.AppAPIKeyExpiry(cfg, keyCfg)
 	if err != nil {
 		return err
 	}
+	expirySource := "reported by Kion"
+	if keyCfg.Expiry.IsZero() {
+		expirySource = "computed from app-api-key-duration"
+	}
+
+	id := "unknown (created by an older version; run \"kion key rotate\" to record it)"
+	if keyCfg.ID != 0 {
+		id = strconv.Itoa(keyCfg.ID)
+	}
+	remaining := time.Until(expiry).Round(time.Minute)
+	remainingStr := remaining.String()
+	if remaining <= 0 {
+		remainingStr = "expired"
+	}
+	rotation := "off"
+	if cfg.Bool("rotate-app-api-keys") {
+		rotation = fmt.Sprintf("automatic (within %v of expiry)", util.RotationWindow)
+	}
+
+	fmt.Printf("ID:         %v\n", id)
+	if keyCfg.Name != "" {
+		fmt.Printf("Name:       %v\n", keyCfg.Name)
+	}
+	fmt.Printf("Created:    %v\n", keyCfg.Created.Local().Format(time.RFC1123))
+	fmt.Printf("Expires:    %v (%v)\n", expiry.Local().Format(time.RFC1123), expirySource)
+	fmt.Printf("Remaining:  %v\n", remainingStr)
+	fmt.Printf("Rotation:   %v\n", rotation)
+
+	return nil
+}
 
-	key, err := kion.CreateAppAPIKey(util.AppAPIKeyName)
+func runList(cfg *config.Config, keyCfg *config.KeyConfig) error {
+	kion, err := util.NewClient(cfg, keyCfg)
 	if err != nil {
 		return err
 	}
-	keyMetadata, err := kion.GetAppAPIKeyMetadata(key.ID)
+	keys, err := kion.ListAppAPIKeys()
 	if err != nil {
 		return err
 	}
 
-	keyCfg.Key = key.Key
-	keyCfg.Created = keyMetadata.Created
-	return keyCfg.Save()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tCREATED\t")
+	for _, key := range keys {
+		current := ""
+		if key.ID == keyCfg.ID {
+			current = "(current)"
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", key.ID, key.Name, key.Created.Local().Format(time.RFC1123), current)
+	}
+	return w.Flush()
 }
 
-func runRotate(cfg *config.Config, keyCfg *config.KeyConfig) error {
-	host, err := cfg.StringErr("host")
+func runRevoke(cfg *config.Config, keyCfg *config.KeyConfig, idArg string) error {
+	id, err := strconv.Atoi(idArg)
+	if err != nil {
+		return fmt.Errorf("invalid key ID: %v", idArg)
+	}
+
+	kion, err := util.NewClient(cfg, keyCfg)
 	if err != nil {
 		return err
 	}
-	appAPIKeyDuration, err := cfg.DurationErr("app-api-key-duration")
+	// NewClient may have rotated the key, so compare with keyCfg now
+	if id == keyCfg.ID {
+		return errors.New("refusing to revoke the current key; run \"kion key create --force\" to replace it first")
+	}
+
+	ok, err := confirm(cfg, fmt.Sprintf("Revoke App API Key %v?", id))
+	if err != nil || !ok {
+		return err
+	}
+	err = kion.DeleteAppAPIKey(id)
 	if err != nil {
 		return err
 	}
+	return util.ForgetPreviousAppAPIKeys(keyCfg, []int{id})
+}
 
-	kion := client.NewWithAppAPIKey(host, keyCfg.Key, keyCfg.Created.Add(appAPIKeyDuration))
-	key, err := kion.RotateAppAPIKey(keyCfg.Key)
+func runPrune(cfg *config.Config, keyCfg *config.KeyConfig) error {
+	kion, err := util.NewClient(cfg, keyCfg)
+	if err != nil {
+		return err
+	}
+	keys, err := kion.ListAppAPIKeys()
 	if err != nil {
 		return err
 	}
 
-	// can't know exact expiry before getting metadata, so pass zero Time meaning "no expiry"
-	kion = client.NewWithAppAPIKey(host, key.Key, time.Time{})
-	keyMetadata, err := kion.GetAppAPIKeyMetadata(key.ID)
+	// NewClient may have rotated the key, so compare with keyCfg now
+	previous, others, err := pruneCandidates(keys, keyCfg)
 	if err != nil {
 		return err
 	}
+	if !cfg.Bool("all-hosts") {
+		if len(others) > 0 {
+			fmt.Printf("Skipping %v keys this config didn't use (use --all-hosts to include them)\n", len(others))
+		}
+		others = nil
+	}
+	if len(previous) == 0 && len(others) == 0 {
+		fmt.Println("No keys to prune")
+		return nil
+	}
+
+	prune := []client.AppAPIKeyMetadata{}
+	if len(previous) > 0 {
+		printKeys(previous)
+		ok, err := confirm(cfg, fmt.Sprintf("Revoke these %v App API Keys previously used by this config?", len(previous)))
+		if err != nil {
+			return err
+		}
+		if ok {
+			prune = append(prune, previous...)
+		}
+	}
+	if len(others) > 0 {
+		// --all-hosts already opts in to revoking these, so --yes covers them
+		printKeys(others)
+		ok, err := confirm(cfg, fmt.Sprintf("Revoke these %v App API Keys this config didn't use? They may be in use on other hosts.", len(others)))
+		if err != nil {
+			return err
+		}
+		if ok {
+			prune = append(prune, others...)
+		}
+	}
 
-	keyCfg.Key = key.Key
-	keyCfg.Created = keyMetadata.Created
-	return keyCfg.Save()
+	revoked := []int{}
+	for _, key := range prune {
+		err = kion.DeleteAppAPIKey(key.ID)
+		if err != nil {
+			err = fmt.Errorf("revoking key %v: %w", key.ID, err)
+			break
+		}
+		revoked = append(revoked, key.ID)
+	}
+	if len(revoked) > 0 {
+		forgetErr := util.ForgetPreviousAppAPIKeys(keyCfg, revoked)
+		if err == nil {
+			err = forgetErr
+		}
+	}
+	return err
+}
+
+func printKeys(keys []client.AppAPIKeyMetadata) {
+	for _, key := range keys {
+		fmt.Printf("%v\t%v\t%v\n", key.ID, key.Name, key.Created.Local().Format(time.RFC1123))
+	}
+}
+
+// pruneCandidates returns the keys created by this tool other than the current
+// key: those this config used before (see KeyConfig.PreviousIDs), and the rest,
+// which may be in use on other hosts. It fails if the current key's ID is
+// unknown, since the current key can't then be told apart from the others.
+func pruneCandidates(keys []client.AppAPIKeyMetadata, keyCfg *config.KeyConfig) (previous []client.AppAPIKeyMetadata, others []client.AppAPIKeyMetadata, err error) {
+	if keyCfg.Key != "" && keyCfg.ID == 0 {
+		return nil, nil, errors.New("the current key's ID is unknown (created by an older version); run \"kion key rotate\" to record it before pruning")
+	}
+
+	isPrevious := map[int]bool{}
+	for _, id := range keyCfg.PreviousIDs {
+		isPrevious[id] = true
+	}
+	for _, key := range keys {
+		switch {
+		case key.ID == keyCfg.ID:
+		case isPrevious[key.ID]:
+			previous = append(previous, key)
+		case strings.HasPrefix(key.Name, util.AppAPIKeyName):
+			others = append(others, key)
+		}
+	}
+	return previous, others, nil
+}
+
+// confirm asks the user to confirm an action unless --yes is given.
+func confirm(cfg *config.Config, message string) (bool, error) {
+	if cfg.Bool("yes") {
+		return true, nil
+	}
+	var ok bool
+	err := survey.AskOne(&survey.Confirm{Message: message}, &ok)
+	return ok, err
 }
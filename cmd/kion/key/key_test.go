@@ -0,0 +1,34 @@
+package key
+
+import (
+	"testing"
+
+	"github.com/corbaltcode/kion/cmd/kion/config"
+	"github.com/corbaltcode/kion/internal/client"
+)
+
+func TestPruneCandidates(t *testing.T) {
+	keys := []client.AppAPIKeyMetadata{
+		{ID: 1, Name: "Kion Tool"},
+		{ID: 2, Name: "Kion Tool"},
+		{ID: 3, Name: "CI"},
+		{ID: 4, Name: "Kion Tool laptop"},
+	}
+
+	previous, others, err := pruneCandidates(keys, &config.KeyConfig{ID: 2, Key: "key", PreviousIDs: []int{1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(previous) != 1 || previous[0].ID != 1 {
+		t.Errorf("got previous %+v (want key 1)", previous)
+	}
+	if len(others) != 1 || others[0].ID != 4 {
+		t.Errorf("got others %+v (want key 4)", others)
+	}
+
+	// a key saved before IDs were recorded could be any of them
+	_, _, err = pruneCandidates(keys, &config.KeyConfig{Key: "key"})
+	if err == nil {
+		t.Error("pruned with the current key's ID unknown")
+	}
+}
@@ -3,55 +3,79 @@ package main
 import (
 	"errors"
 	"fmt"
-	"io/fs"
+	"io"
 	"os"
-	"path/filepath"
 
 	"github.com/corbaltcode/kion/cmd/kion/access"
 	"github.com/corbaltcode/kion/cmd/kion/config"
+	"github.com/corbaltcode/kion/cmd/kion/configcmd"
 	"github.com/corbaltcode/kion/cmd/kion/console"
 	"github.com/corbaltcode/kion/cmd/kion/credentialprocess"
 	"github.com/corbaltcode/kion/cmd/kion/credentials"
+	"github.com/corbaltcode/kion/cmd/kion/doctor"
+	"github.com/corbaltcode/kion/cmd/kion/foreach"
 	"github.com/corbaltcode/kion/cmd/kion/key"
 	"github.com/corbaltcode/kion/cmd/kion/login"
 	"github.com/corbaltcode/kion/cmd/kion/logout"
 	"github.com/corbaltcode/kion/cmd/kion/setup"
+	"github.com/corbaltcode/kion/cmd/kion/util"
+	"github.com/corbaltcode/kion/cmd/kion/verify"
+	"github.com/corbaltcode/kion/cmd/kion/whoami"
 	"github.com/corbaltcode/kion/internal/client"
 
-	"github.com/knadh/koanf/parsers/yaml"
-	"github.com/knadh/koanf/providers/file"
-	"github.com/knadh/koanf/providers/posflag"
-	"github.com/knadh/koanf/v2"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/zalando/go-keyring"
 )
 
 func main() {
-	userConfigName, err := config.UserConfigName()
+	// the config dir is needed before flags are parsed, so find it early
+	if dir := configDirFlag(os.Args[1:]); dir != "" {
+		config.SetUserConfigDir(dir)
+	}
+	config.Migrate()
+
+	configLayers, err := config.Layers()
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
-	configPaths := []string{
-		userConfigName,
-		filepath.Join(".", "kion.yml"),
-	}
-
-	k := koanf.New(".")
-
-	for _, path := range configPaths {
-		err = k.Load(file.Provider(path), yaml.Parser())
-		if err != nil && !errors.Is(err, fs.ErrNotExist) {
-			fmt.Fprintf(os.Stderr, "bad config in %v: %v\n", path, err)
-			os.Exit(1)
-		}
+	cfg, configProblems, err := config.Load(configLayers)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 
 	rootCmd := &cobra.Command{
 		Use:  "kion",
 		Args: cobra.NoArgs,
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
-			return k.Load(posflag.Provider(cmd.Flags(), ".", k), nil)
+			err := cfg.LoadFlags(cmd.Flags())
+			if err != nil {
+				return err
+			}
+			if cfg.Bool("debug") {
+				for _, layer := range cfg.Loaded {
+					fmt.Fprintf(os.Stderr, "debug: loaded config: %v\n", layer)
+				}
+			}
+			// the AWS CLI may show anything credential-process writes to stderr
+			if cmd.Name() == "credential-process" {
+				util.Warnings = io.Discard
+			}
+			for _, p := range configProblems {
+				if p.Warning {
+					fmt.Fprintf(util.Warnings, "warning: %v\n", p)
+				}
+			}
+			if !repairsConfig(cmd) {
+				for _, p := range configProblems {
+					if !p.Warning {
+						return p
+					}
+				}
+			}
+			return nil
 		},
 		SilenceErrors: true,
 		SilenceUsage:  true,
@@ -60,33 +84,38 @@ func main() {
 		},
 	}
 
-	cfg := &config.Config{Koanf: k}
+	rootCmd.PersistentFlags().BoolP("debug", "", false, "print debugging information")
+	rootCmd.PersistentFlags().StringP("config-dir", "", "", "user config directory (default $KION_CONFIG_DIR, $XDG_CONFIG_HOME/kion, or ~/.config/kion)")
 
-	keyCfg, err := config.LoadKeyConfig()
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
-	}
+	// loaded on first use (see util.LoadKeyConfig)
+	keyCfg := &config.KeyConfig{}
 
 	rootCmd.AddCommand(access.New(cfg, keyCfg))
+	rootCmd.AddCommand(configcmd.New(cfg))
 	rootCmd.AddCommand(credentialprocess.New(cfg, keyCfg))
 	rootCmd.AddCommand(credentials.New(cfg, keyCfg))
 	rootCmd.AddCommand(console.New(cfg, keyCfg))
+	rootCmd.AddCommand(doctor.New(cfg, keyCfg))
+	rootCmd.AddCommand(foreach.New(cfg, keyCfg))
 	rootCmd.AddCommand(key.New(cfg, keyCfg))
 	rootCmd.AddCommand(login.New(cfg))
 	rootCmd.AddCommand(logout.New(cfg))
 	rootCmd.AddCommand(setup.New())
+	rootCmd.AddCommand(verify.New(cfg, keyCfg))
+	rootCmd.AddCommand(whoami.New(cfg, keyCfg))
 
 	err = rootCmd.Execute()
 	if err != nil {
 		program := os.Args[0]
 		var message string
 		if errors.Is(err, keyring.ErrNotFound) {
-			message = fmt.Sprintf("no credentials; run \"%s login\" to store user credentials in the system keyring or \"%s key create\" to create an app API key", program, program)
+			message = fmt.Sprintf("no credentials; run \"%s login\" to store user credentials or \"%s key create\" to create an app API key", program, program)
 		} else if errors.Is(err, client.ErrInvalidCredentials) {
 			message = fmt.Sprintf("login failed; run \"%s login\" to update credentials", program)
+		} else if errors.Is(err, client.ErrMFARequired) {
+			message = "login requires an MFA code; run interactively or set mfa-command to a command that prints one"
 		} else if errors.Is(err, client.ErrAppAPIKeyExpired) {
-			message = fmt.Sprintf("app API key expired; run \"%s key create --force\"", program)
+			message = fmt.Sprintf("app API key expired; run \"%s key create --force\" (set auto-recreate-app-api-key to do this automatically)", program)
 		} else {
 			message = err.Error()
 		}
@@ -94,3 +123,27 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// repairsConfig reports whether cmd is used to diagnose or fix the config, and
+// so must run even if the config has errors.
+func repairsConfig(cmd *cobra.Command) bool {
+	for ; cmd != nil; cmd = cmd.Parent() {
+		switch cmd.Name() {
+		case "config", "doctor", "setup":
+			return true
+		}
+	}
+	return false
+}
+
+// configDirFlag returns the value of --config-dir in args, ignoring all other
+// flags.
+func configDirFlag(args []string) string {
+	flags := pflag.NewFlagSet("", pflag.ContinueOnError)
+	flags.ParseErrorsWhitelist.UnknownFlags = true
+	flags.SetOutput(io.Discard)
+	dir := flags.String("config-dir", "", "")
+	// errors (e.g. --help) are reported when cobra parses the flags
+	flags.Parse(args)
+	return *dir
+}
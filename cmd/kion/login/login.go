@@ -9,19 +9,28 @@ import (
 	"github.com/corbaltcode/kion/cmd/kion/util"
 	"github.com/corbaltcode/kion/internal/client"
 	"github.com/spf13/cobra"
-	"github.com/zalando/go-keyring"
 )
 
 func New(cfg *config.Config) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "login",
 		Short: "Saves credentials to system keyring",
-		Args:  cobra.NoArgs,
+		Long: `Saves credentials to system keyring
+
+The password is saved where password-storage says (the system keyring by
+default). If password-command is set, login only checks that its password
+works.
+
+With login-method set to sso, login opens the IDMS's login page in a browser
+and saves the resulting token instead of a password.`,
+		Args: cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return run(cfg)
 		},
 	}
 
+	cmd.Flags().StringP("login-method", "", "password", "how to log in (password or sso)")
+
 	return cmd
 }
 
@@ -34,11 +43,29 @@ func run(cfg *config.Config) error {
 	if err != nil {
 		return err
 	}
+	if cfg.String("login-method") == util.LoginMethodSSO {
+		token, err := util.SSOLogin(host, idms)
+		if err != nil {
+			return err
+		}
+		return util.SaveToken(cfg.String("password-storage"), host, idms, cfg.String("username"), token)
+	}
+
 	username, err := cfg.StringErr("username")
 	if err != nil {
 		return err
 	}
 
+	if cfg.String("password-command") != "" {
+		// nothing to save; just check that the command's password works
+		password, err := util.UserPassword(cfg, host, idms, username)
+		if err != nil {
+			return err
+		}
+		_, err = util.Login(host, idms, username, password, cfg.String("mfa-command"))
+		return err
+	}
+
 	var password string
 
 	for {
@@ -51,7 +78,7 @@ func run(cfg *config.Config) error {
 			return err
 		}
 
-		_, err := client.Login(host, idms, username, password)
+		_, err := util.Login(host, idms, username, password, cfg.String("mfa-command"))
 
 		if errors.Is(err, client.ErrInvalidCredentials) {
 			fmt.Println("Invalid credentials")
@@ -62,5 +89,5 @@ func run(cfg *config.Config) error {
 		}
 	}
 
-	return keyring.Set(util.KeyringService(host, idms), username, password)
+	return util.SetSecret(cfg.String("password-storage"), util.KeyringService(host, idms), username, password)
 }
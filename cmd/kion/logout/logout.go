@@ -4,13 +4,12 @@ import (
 	"github.com/corbaltcode/kion/cmd/kion/config"
 	"github.com/corbaltcode/kion/cmd/kion/util"
 	"github.com/spf13/cobra"
-	"github.com/zalando/go-keyring"
 )
 
 func New(cfg *config.Config) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "logout",
-		Short: "Removes credentials from system keyring",
+		Short: "Removes credentials and saved tokens from system keyring",
 		Args:  cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return run(cfg)
@@ -29,10 +28,19 @@ func run(cfg *config.Config) error {
 	if err != nil {
 		return err
 	}
+	storage := cfg.String("password-storage")
+	err = util.DeleteToken(storage, host, idms, cfg.String("username"))
+	if err != nil {
+		return err
+	}
+	if cfg.String("login-method") == util.LoginMethodSSO {
+		return nil
+	}
+
 	username, err := cfg.StringErr("username")
 	if err != nil {
 		return err
 	}
 
-	return keyring.Delete(util.KeyringService(host, idms), username)
+	return util.DeleteSecret(storage, util.KeyringService(host, idms), username)
 }
@@ -1,11 +1,13 @@
 package setup
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
-	"io/fs"
+	"io"
 	"os"
-	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/AlecAivazis/survey/v2"
@@ -13,52 +15,69 @@ import (
 	"github.com/corbaltcode/kion/cmd/kion/util"
 	"github.com/corbaltcode/kion/internal/client"
 	"github.com/spf13/cobra"
-	"github.com/zalando/go-keyring"
-	"gopkg.in/yaml.v3"
+	"github.com/spf13/pflag"
 )
 
 func New() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "setup",
 		Short: "Interactive setup",
-		Args:  cobra.NoArgs,
+		Long: `Interactive setup
+
+Each prompt can be answered in advance with a flag or the corresponding
+environment variable (e.g. --host or KION_HOST). With --non-interactive, setup
+fails instead of prompting for a missing answer; prompts with defaults use
+their defaults.
+
+Settings already in the config file are offered as defaults. Setup updates only
+the settings it asks about, leaving other settings and comments in place, and
+saves the previous file with a .bak extension.`,
+		Args: cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return run()
+			return run(cmd.Flags())
 		},
 	}
 
+	cmd.Flags().StringP("host", "", "", "Kion host")
+	cmd.Flags().StringP("idms", "", "", "ID management system name or ID")
+	cmd.Flags().StringP("login-method", "", "password", "how to log in (password or sso)")
+	cmd.Flags().StringP("username", "", "", "username")
+	cmd.Flags().BoolP("password-stdin", "", false, "read password from stdin")
+	cmd.Flags().StringP("password-command", "", "", "command that prints the password, used instead of saving it")
+	cmd.Flags().StringP("password-storage", "", "keyring", "where to save the password (keyring or file)")
+	cmd.Flags().StringP("mfa-command", "", "", "command that prints an MFA code, if the IDMS requires one")
+	cmd.Flags().BoolP("create-app-api-key", "", true, "create an App API Key instead of saving user credentials")
+	cmd.Flags().BoolP("rotate-app-api-keys", "", true, "automatically rotate App API Keys")
+	cmd.Flags().StringP("app-api-key-duration", "", "168h", "duration of App API Keys")
+	cmd.Flags().StringP("session-duration", "", "60m", "duration of temporary credentials")
+	cmd.Flags().BoolP("non-interactive", "", false, "fail instead of prompting")
+
 	return cmd
 }
 
-func run() error {
-	userConfigName, err := config.UserConfigName()
+func run(flags *pflag.FlagSet) error {
+	p := prompter{flags: flags}
+	nonInteractive, err := p.confirm("non-interactive", nil)
 	if err != nil {
 		return err
 	}
-	userConfigExists, err := fileExists(userConfigName)
+	p.nonInteractive = nonInteractive
+	// an MFA code can't be asked for either
+	util.Interactive = !nonInteractive
+
+	userConfigName, err := config.UserConfigName()
 	if err != nil {
 		return err
 	}
-	if userConfigExists {
-		var overwrite bool
-		err = survey.AskOne(
-			&survey.Confirm{Message: fmt.Sprintf("Config file '%v' exists; overwrite?", userConfigName)},
-			&overwrite,
-		)
-		if err != nil {
-			return err
-		}
-		if !overwrite {
-			return nil
-		}
+	userConfig, err := config.LoadFile(userConfigName)
+	if err != nil {
+		return err
 	}
 
-	var host string
-	err = survey.AskOne(
-		&survey.Input{Message: "Kion host:"},
-		&host,
-		survey.WithValidator(survey.Required),
-	)
+	// existing settings are the defaults
+	p.current = userConfig
+
+	host, err := p.input("host", &survey.Input{Message: "Kion host:"})
 	if err != nil {
 		return err
 	}
@@ -71,46 +90,35 @@ func run() error {
 		return fmt.Errorf("empty IDMS list")
 	}
 
-	idmsNames := []string{}
-	for _, idms := range idmss {
-		idmsNames = append(idmsNames, idms.Name)
+	idms, err := p.idms(idmss)
+	if err != nil {
+		return err
 	}
 
-	var idmsAnswer survey.OptionAnswer
-	err = survey.AskOne(
-		&survey.Select{Message: "ID Management System:", Options: idmsNames},
-		&idmsAnswer,
-	)
+	loginMethod, err := p.loginMethod()
 	if err != nil {
 		return err
 	}
-	idms := idmss[idmsAnswer.Index]
+	mfaCommand := p.value("mfa-command")
+	passwordCommand := p.value("password-command")
 
 	var username string
 	var password string
 	var kion *client.Client
 
-	for {
-		err = survey.AskOne(
-			&survey.Input{Message: "Username:"},
-			&username,
-			survey.WithValidator(survey.Required),
-		)
+	for loginMethod == util.LoginMethodPassword {
+		username, err = p.input("username", &survey.Input{Message: "Username:"})
 		if err != nil {
 			return err
 		}
 
-		err = survey.AskOne(
-			&survey.Password{Message: "Password:"},
-			&password,
-			survey.WithValidator(survey.Required),
-		)
+		password, err = p.password()
 		if err != nil {
 			return err
 		}
 
-		kion, err = client.Login(host, idms.ID, username, password)
-		if errors.Is(err, client.ErrInvalidCredentials) {
+		kion, err = util.Login(host, idms.ID, username, password, mfaCommand)
+		if errors.Is(err, client.ErrInvalidCredentials) && !p.isSet("password-stdin") && passwordCommand == "" {
 			fmt.Println("Invalid credentials")
 		} else if err != nil {
 			return err
@@ -119,109 +127,380 @@ func run() error {
 		}
 	}
 
-	var appAPIKeyAnswer survey.OptionAnswer
-	err = survey.AskOne(
-		&survey.Select{
-			Message: "Create App API Key?",
-			Options: []string{"Yes (recommended)", "No (user credentials will be saved in system keyring)"},
-		},
-		&appAPIKeyAnswer,
-	)
+	if loginMethod == util.LoginMethodSSO {
+		// SSO logins don't need a username, but keep any that was given
+		username = p.value("username")
+		token, err := util.SSOLogin(host, idms.ID)
+		if err != nil {
+			return err
+		}
+		kion = client.NewWithToken(host, token)
+	}
+
+	createAppAPIKey, err := p.selectYesNo("create-app-api-key", &survey.Select{
+		Message: "Create App API Key?",
+		Options: []string{"Yes (recommended)", "No (user credentials will be saved in system keyring or secrets file)"},
+	})
 	if err != nil {
 		return err
 	}
 
-	appAPIKey := &client.AppAPIKey{}
-	appAPIKeyMetadata := &client.AppAPIKeyMetadata{}
+	keyStorage, _ := userConfig.Get("app-api-key-storage")
 
-	if appAPIKeyAnswer.Index == 0 {
-		appAPIKey, err = kion.CreateAppAPIKey(util.AppAPIKeyName)
+	if createAppAPIKey {
+		nameTemplate, _ := userConfig.Get("app-api-key-name")
+		name, err := util.RenderAppAPIKeyName(nameTemplate, username)
 		if err != nil {
 			return err
 		}
-		appAPIKeyMetadata, err = kion.GetAppAPIKeyMetadata(appAPIKey.ID)
+		err = saveAppAPIKey(kion, host, keyStorage, name)
+		if err != nil {
+			return err
+		}
+	} else if loginMethod == util.LoginMethodSSO {
+		err = util.SaveToken(p.value("password-storage"), host, idms.ID, username, kion.Token())
+		if err != nil {
+			return err
+		}
+		err = saveAppAPIKey(kion, host, keyStorage, "")
 		if err != nil {
 			return err
 		}
 	} else {
-		err = keyring.Set(util.KeyringService(host, idms.ID), username, password)
+		// with a password command, there's no password to save
+		if passwordCommand == "" {
+			err = util.SetSecret(p.value("password-storage"), util.KeyringService(host, idms.ID), username, password)
+			if err != nil {
+				return err
+			}
+		}
+		err = saveAppAPIKey(kion, host, keyStorage, "")
 		if err != nil {
 			return err
 		}
 	}
 
-	var rotateAppAPIKeys bool
-	err = survey.AskOne(
-		&survey.Confirm{
-			Message: "Automatically rotate App API Keys?",
-			Default: true,
-		},
-		&rotateAppAPIKeys,
-	)
+	rotateAppAPIKeys, err := p.confirm("rotate-app-api-keys", &survey.Confirm{Message: "Automatically rotate App API Keys?"})
 	if err != nil {
 		return err
 	}
 
-	var appAPIKeyDuration time.Duration
-	err = survey.AskOne(
-		&survey.Input{Message: "Duration of App API Keys:", Default: "168h"},
-		&appAPIKeyDuration,
-		survey.WithValidator(survey.Required),
-		survey.WithValidator(validateDuration),
-	)
+	appAPIKeyDuration, err := p.duration("app-api-key-duration", &survey.Input{Message: "Duration of App API Keys:"})
 	if err != nil {
 		return err
 	}
 
-	var sessionDuration time.Duration
-	err = survey.AskOne(
-		&survey.Input{Message: "Duration of temporary credentials:", Default: "60m"},
-		&sessionDuration,
-		survey.WithValidator(survey.Required),
-		survey.WithValidator(validateDuration),
-	)
+	sessionDuration, err := p.duration("session-duration", &survey.Input{Message: "Duration of temporary credentials:"})
 	if err != nil {
 		return err
 	}
 
-	settings := map[string]interface{}{
-		"app-api-key-duration": appAPIKeyDuration,
-		"host":                 host,
-		"idms":                 idms.ID,
-		"rotate-app-api-keys":  rotateAppAPIKeys,
-		"session-duration":     sessionDuration,
-		"username":             username,
+	type setting struct {
+		key   string
+		value interface{}
+	}
+	settings := []setting{
+		{"host", host},
+		{"idms", idms.ID},
+		{"login-method", loginMethod},
+		{"username", username},
+		{"rotate-app-api-keys", rotateAppAPIKeys},
+		{"app-api-key-duration", appAPIKeyDuration},
+		{"session-duration", sessionDuration},
+	}
+	// optional settings are saved only if given
+	for _, name := range []string{"mfa-command", "password-command", "password-storage"} {
+		if p.isSet(name) {
+			settings = append(settings, setting{name, p.value(name)})
+		}
+	}
+	for _, setting := range settings {
+		err = userConfig.Set(setting.key, setting.value)
+		if err != nil {
+			return err
+		}
 	}
 
-	userConfigDir := filepath.Dir(userConfigName)
-	err = os.MkdirAll(userConfigDir, 0700)
+	backupName, err := userConfig.Backup()
 	if err != nil {
 		return err
 	}
-	f, err := os.OpenFile(userConfigName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	err = userConfig.Save()
 	if err != nil {
 		return err
 	}
-	defer f.Close()
+	if backupName != "" {
+		fmt.Printf("Updated %v (previous version saved to %v)\n", userConfigName, backupName)
+	}
+
+	return nil
+}
 
-	err = yaml.NewEncoder(f).Encode(settings)
+// saveAppAPIKey creates an App API Key with the given name and saves it in
+// place of the current key, or if name is empty, just removes the current key.
+// A replaced key is kept in the key config's previous IDs so that "kion key
+// prune" can revoke it.
+func saveAppAPIKey(kion *client.Client, host string, storage string, name string) error {
+	keyCfg := &config.KeyConfig{}
+	unlock, err := util.LockAndReloadKeyConfig(keyCfg)
 	if err != nil {
 		return err
 	}
+	defer unlock()
+	if keyCfg.Host != host {
+		// keys for another host can't be revoked on this one
+		*keyCfg = config.KeyConfig{}
+	}
+	keyCfg.Storage = storage
 
-	keyCfg := config.KeyConfig{
-		Key:     appAPIKey.Key,
-		Created: appAPIKeyMetadata.Created,
+	if name != "" {
+		key, err := kion.CreateAppAPIKey(name)
+		if err != nil {
+			return err
+		}
+		return util.SaveAppAPIKey(keyCfg, host, key)
+	}
+
+	for _, id := range []int{keyCfg.ID, keyCfg.PendingID} {
+		if id != 0 {
+			keyCfg.PreviousIDs = append(keyCfg.PreviousIDs, id)
+		}
 	}
+	// saving without the key also deletes it from the keyring
+	*keyCfg = config.KeyConfig{Host: host, Storage: storage, PreviousIDs: keyCfg.PreviousIDs}
 	return keyCfg.Save()
 }
 
-func fileExists(name string) (bool, error) {
-	_, err := os.Stat(name)
-	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+// prompter answers setup prompts from flags and environment variables, falling
+// back to asking the user unless nonInteractive is set. A flag's default value
+// is used as the prompt's default unless the existing config has a value.
+type prompter struct {
+	flags          *pflag.FlagSet
+	current        *config.File
+	nonInteractive bool
+}
+
+// lookup returns the value of the named flag if it was set on the command line
+// or else the value of the corresponding environment variable (e.g. KION_HOST
+// for host).
+func (p *prompter) lookup(name string) (string, bool) {
+	if p.flags.Changed(name) {
+		return p.flags.Lookup(name).Value.String(), true
+	}
+	return os.LookupEnv(config.EnvVar(name))
+}
+
+// def returns the default answer for the named flag: the existing config value
+// if there is one, or else the flag's default.
+func (p *prompter) def(name string) string {
+	if p.current != nil {
+		if v, ok := p.current.Get(name); ok && v != "" {
+			return v
+		}
+	}
+	return p.flags.Lookup(name).DefValue
+}
+
+// value returns the preset answer for the named flag, or else its default.
+func (p *prompter) value(name string) string {
+	if v, ok := p.lookup(name); ok {
+		return v
+	}
+	return p.def(name)
+}
+
+func (p *prompter) isSet(name string) bool {
+	_, ok := p.lookup(name)
+	return ok
+}
+
+// answer returns the preset answer for the named flag. If there is none and
+// prompting isn't allowed, answer returns the default, or an error if there is
+// no default.
+func (p *prompter) answer(name string) (string, bool, error) {
+	if v, ok := p.lookup(name); ok {
+		return v, true, nil
+	}
+	if p.nonInteractive {
+		def := p.def(name)
+		if def == "" {
+			return "", false, fmt.Errorf("missing --%v (or %v) in non-interactive mode", name, config.EnvVar(name))
+		}
+		return def, true, nil
+	}
+	return "", false, nil
+}
+
+func (p *prompter) input(name string, prompt *survey.Input) (string, error) {
+	v, ok, err := p.answer(name)
+	if err != nil || ok {
+		return v, err
+	}
+
+	prompt.Default = p.def(name)
+	err = survey.AskOne(prompt, &v, survey.WithValidator(survey.Required))
+	return v, err
+}
+
+func (p *prompter) duration(name string, prompt *survey.Input) (time.Duration, error) {
+	v, ok, err := p.answer(name)
+	if err != nil {
+		return 0, err
+	}
+	if ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %v: %w", name, err)
+		}
+		return d, nil
+	}
+
+	var d time.Duration
+	prompt.Default = p.def(name)
+	err = survey.AskOne(prompt, &d, survey.WithValidator(survey.Required), survey.WithValidator(validateDuration))
+	return d, err
+}
+
+// confirm answers a yes/no prompt. A nil prompt means the flag can't be asked
+// for interactively.
+func (p *prompter) confirm(name string, prompt *survey.Confirm) (bool, error) {
+	v, ok, err := p.answer(name)
+	if err != nil {
 		return false, err
 	}
-	return err == nil, nil
+	if ok || prompt == nil {
+		if v == "" {
+			v = p.def(name)
+		}
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return false, fmt.Errorf("invalid %v: %w", name, err)
+		}
+		return b, nil
+	}
+
+	prompt.Default, _ = strconv.ParseBool(p.def(name))
+	var b bool
+	err = survey.AskOne(prompt, &b)
+	return b, err
+}
+
+// selectYesNo answers a yes/no prompt presented as a two-option select whose
+// first option means yes.
+func (p *prompter) selectYesNo(name string, prompt *survey.Select) (bool, error) {
+	v, ok, err := p.answer(name)
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return false, fmt.Errorf("invalid %v: %w", name, err)
+		}
+		return b, nil
+	}
+
+	var answer survey.OptionAnswer
+	err = survey.AskOne(prompt, &answer)
+	return answer.Index == 0, err
+}
+
+// idms selects an IDMS by the name or ID given in the idms flag, or asks.
+func (p *prompter) idms(idmss []client.IDMS) (client.IDMS, error) {
+	v, ok, err := p.answer("idms")
+	if err != nil {
+		return client.IDMS{}, err
+	}
+	if ok {
+		for _, idms := range idmss {
+			if strings.EqualFold(v, idms.Name) || v == strconv.Itoa(idms.ID) {
+				return idms, nil
+			}
+		}
+		return client.IDMS{}, fmt.Errorf("no IDMS with name or ID %q", v)
+	}
+
+	idmsNames := []string{}
+	for _, idms := range idmss {
+		idmsNames = append(idmsNames, idms.Name)
+	}
+
+	prompt := &survey.Select{Message: "ID Management System:", Options: idmsNames}
+	for _, idms := range idmss {
+		if p.def("idms") == strconv.Itoa(idms.ID) {
+			prompt.Default = idms.Name
+		}
+	}
+
+	var answer survey.OptionAnswer
+	err = survey.AskOne(prompt, &answer)
+	if err != nil {
+		return client.IDMS{}, err
+	}
+	return idmss[answer.Index], nil
+}
+
+// loginMethod selects how to log in, as given in the login-method flag, or
+// asks.
+func (p *prompter) loginMethod() (string, error) {
+	v, ok, err := p.answer("login-method")
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		if v != util.LoginMethodPassword && v != util.LoginMethodSSO {
+			return "", fmt.Errorf("invalid login-method: %v (want %v or %v)", v, util.LoginMethodPassword, util.LoginMethodSSO)
+		}
+		return v, nil
+	}
+
+	prompt := &survey.Select{
+		Message: "Login method:",
+		Options: []string{"Password", "SSO (log in with a browser)"},
+	}
+	if p.def("login-method") == util.LoginMethodSSO {
+		prompt.Default = prompt.Options[1]
+	}
+
+	var answer survey.OptionAnswer
+	err = survey.AskOne(prompt, &answer)
+	if err != nil {
+		return "", err
+	}
+	if answer.Index == 1 {
+		return util.LoginMethodSSO, nil
+	}
+	return util.LoginMethodPassword, nil
+}
+
+// password runs the password command if there is one, reads the password from
+// stdin if --password-stdin is set, or asks.
+func (p *prompter) password() (string, error) {
+	if command := p.value("password-command"); command != "" {
+		return util.RunCommand(command)
+	}
+	fromStdin, err := p.confirm("password-stdin", nil)
+	if err != nil {
+		return "", err
+	}
+	if fromStdin {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+			return "", fmt.Errorf("reading password from stdin: %w", err)
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+	if p.nonInteractive {
+		return "", errors.New("missing --password-stdin in non-interactive mode")
+	}
+
+	var password string
+	err = survey.AskOne(
+		&survey.Password{Message: "Password:"},
+		&password,
+		survey.WithValidator(survey.Required),
+	)
+	return password, err
 }
 
 func validateDuration(t interface{}) error {
@@ -0,0 +1,32 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// RunCommand runs command with the system shell and returns its output with
+// surrounding whitespace trimmed. The command's stderr is passed through so
+// that it can prompt the user (e.g. to unlock a password manager).
+func RunCommand(command string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	stdout := new(bytes.Buffer)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = os.Stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("running %q: %w", command, err)
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
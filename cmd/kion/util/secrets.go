@@ -0,0 +1,125 @@
+package util
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/AlecAivazis/survey/v2"
+	"github.com/corbaltcode/kion/cmd/kion/config"
+	"github.com/zalando/go-keyring"
+	"golang.org/x/term"
+)
+
+// Values of the password-storage setting.
+const (
+	PasswordStorageKeyring = "keyring"
+	PasswordStorageFile    = "file"
+)
+
+// passphraseEnvVar names the environment variable holding the passphrase of the
+// secrets file. It isn't a setting so that it can't end up in a config file.
+const passphraseEnvVar = "KION_SECRETS_PASSPHRASE"
+
+// passphrase is the secrets file passphrase once it has been read or asked for.
+var passphrase string
+
+// GetSecret returns a secret from the given storage ("" means the keyring). It
+// returns keyring.ErrNotFound if there is no such secret.
+func GetSecret(storage string, service string, user string) (string, error) {
+	switch storage {
+	case "", PasswordStorageKeyring:
+		return keyring.Get(service, user)
+	case PasswordStorageFile:
+		passphrase, err := secretsPassphrase()
+		if err != nil {
+			return "", err
+		}
+		return config.GetSecret(passphrase, secretName(service, user))
+	default:
+		return "", invalidStorage(storage)
+	}
+}
+
+// SetSecret saves a secret in the given storage ("" means the keyring).
+func SetSecret(storage string, service string, user string, secret string) error {
+	switch storage {
+	case "", PasswordStorageKeyring:
+		return keyring.Set(service, user, secret)
+	case PasswordStorageFile:
+		passphrase, err := secretsPassphrase()
+		if err != nil {
+			return err
+		}
+		return config.SetSecret(passphrase, secretName(service, user), secret)
+	default:
+		return invalidStorage(storage)
+	}
+}
+
+// DeleteSecret deletes a secret from the given storage ("" means the keyring).
+// It returns keyring.ErrNotFound if there is no such secret.
+func DeleteSecret(storage string, service string, user string) error {
+	switch storage {
+	case "", PasswordStorageKeyring:
+		return keyring.Delete(service, user)
+	case PasswordStorageFile:
+		passphrase, err := secretsPassphrase()
+		if err != nil {
+			return err
+		}
+		_, err = config.GetSecret(passphrase, secretName(service, user))
+		if err != nil {
+			return err
+		}
+		return config.SetSecret(passphrase, secretName(service, user), "")
+	default:
+		return invalidStorage(storage)
+	}
+}
+
+func secretName(service string, user string) string {
+	return service + " " + user
+}
+
+func invalidStorage(storage string) error {
+	return fmt.Errorf("invalid password-storage: %v (want %v or %v)", storage, PasswordStorageKeyring, PasswordStorageFile)
+}
+
+// availablePassphrase returns the passphrase of the secrets file if it has been
+// read already or is in $KION_SECRETS_PASSPHRASE, without asking for it.
+func availablePassphrase() (string, bool) {
+	if passphrase != "" {
+		return passphrase, true
+	}
+	if v := os.Getenv(passphraseEnvVar); v != "" {
+		passphrase = v
+		return passphrase, true
+	}
+	return "", false
+}
+
+// secretsPassphrase returns the passphrase of the secrets file from
+// $KION_SECRETS_PASSPHRASE, or asks for it if Interactive is set and stdin is a
+// terminal.
+func secretsPassphrase() (string, error) {
+	if passphrase, ok := availablePassphrase(); ok {
+		return passphrase, nil
+	}
+	if !Interactive || !term.IsTerminal(int(os.Stdin.Fd())) {
+		return "", fmt.Errorf("password-storage is %v but %v isn't set", PasswordStorageFile, passphraseEnvVar)
+	}
+
+	name, err := config.SecretsName()
+	if err != nil {
+		return "", err
+	}
+	err = survey.AskOne(
+		&survey.Password{Message: fmt.Sprintf("Passphrase for %v:", name)},
+		&passphrase,
+		survey.WithValidator(survey.Required),
+	)
+	if err != nil {
+		return "", err
+	}
+	return passphrase, nil
+}
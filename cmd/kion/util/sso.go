@@ -0,0 +1,94 @@
+package util
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/corbaltcode/kion/internal/client"
+	"github.com/pkg/browser"
+)
+
+// Values of the login-method setting.
+const (
+	LoginMethodPassword = "password"
+	LoginMethodSSO      = "sso"
+)
+
+// ErrLoginRequired is returned when an SSO login is needed but the user can't
+// be sent to a browser.
+var ErrLoginRequired = errors.New("SSO session expired; run \"kion login\"")
+
+// ssoTimeout is how long to wait for the user to finish logging in.
+const ssoTimeout = 5 * time.Minute
+
+// SSOLogin logs in through the IDMS's SSO page in a browser. Kion has the
+// browser post the token to a local endpoint.
+func SSOLogin(host string, idms int) (*client.Token, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	// an unguessable path keeps other local pages from supplying a token
+	secret := make([]byte, 16)
+	_, err = rand.Read(secret)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	path := "/" + hex.EncodeToString(secret)
+	callback := fmt.Sprintf("http://%s%s", listener.Addr(), path)
+
+	type result struct {
+		token *client.Token
+		err   error
+	}
+	results := make(chan result, 1)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		// the token comes in a form post; in a query string it would end up in
+		// the browser's history
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		err := r.ParseForm()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		token, err := client.ParseSAMLCallback(r.PostForm)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			fmt.Fprintln(w, "Logged in to Kion. You can close this window.")
+		}
+		select {
+		case results <- result{token, err}:
+		default:
+		}
+	})
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+	defer server.Close()
+
+	loginURL := client.SAMLLoginURL(host, idms, callback)
+	fmt.Fprintf(os.Stderr, "Opening a browser to log in. If it doesn't open, visit:\n\n  %v\n\n", loginURL)
+	// the user can still visit the URL if no browser opens
+	browser.OpenURL(loginURL)
+
+	select {
+	case r := <-results:
+		return r.token, r.err
+	case <-time.After(ssoTimeout):
+		return nil, errors.New("timed out waiting for SSO login")
+	}
+}
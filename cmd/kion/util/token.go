@@ -0,0 +1,210 @@
+package util
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/corbaltcode/kion/cmd/kion/config"
+	"github.com/corbaltcode/kion/internal/client"
+	"github.com/zalando/go-keyring"
+)
+
+// tokenService is the keyring service (or secrets file prefix) under which
+// user tokens are saved. The user is the token's host, IDMS, and username (see
+// tokenUser).
+const tokenService = "kion-token"
+
+// Saved tokens are renewed when they expire within tokenExpiryMargin.
+const tokenExpiryMargin = 5 * time.Minute
+
+// UserClient logs in with the user's credentials according to login-method:
+// with the password (see UserPassword), or through the IDMS's SSO page in a
+// browser (see SSOLogin).
+//
+// The resulting token is saved in password-storage and reused by later calls
+// until it nears expiry, after which it's renewed with its refresh token if
+// possible. If Kion rejects the token, the Client logs in again.
+func UserClient(cfg *config.Config) (*client.Client, error) {
+	host, err := cfg.StringErr("host")
+	if err != nil {
+		return nil, err
+	}
+	idms, err := cfg.IntErr("idms")
+	if err != nil {
+		return nil, err
+	}
+	username := cfg.String("username")
+	storage := cfg.String("password-storage")
+
+	var login func() (*client.Token, error)
+	switch method := cfg.String("login-method"); method {
+	case "", LoginMethodPassword:
+		username, err = cfg.StringErr("username")
+		if err != nil {
+			return nil, err
+		}
+		login = func() (*client.Token, error) {
+			password, err := UserPassword(cfg, host, idms, username)
+			if err != nil {
+				return nil, err
+			}
+			kion, err := Login(host, idms, username, password, cfg.String("mfa-command"))
+			if err != nil {
+				return nil, err
+			}
+			return kion.Token(), nil
+		}
+	case LoginMethodSSO:
+		login = func() (*client.Token, error) {
+			if !Interactive {
+				return nil, ErrLoginRequired
+			}
+			return SSOLogin(host, idms)
+		}
+	default:
+		return nil, fmt.Errorf("invalid login-method: %v (want %v or %v)", method, LoginMethodPassword, LoginMethodSSO)
+	}
+
+	// loginAndSave logs in and saves the token; failing to save it only means
+	// the next run logs in again
+	loginAndSave := func() (*client.Token, error) {
+		token, err := login()
+		if err != nil {
+			return nil, err
+		}
+		err = SaveToken(storage, host, idms, username, token)
+		if err != nil {
+			fmt.Fprintf(Warnings, "warning: saving token: %v\n", err)
+		}
+		return token, nil
+	}
+
+	token := savedToken(storage, host, idms, username)
+	if token == nil {
+		token, err = loginAndSave()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	kion := client.NewWithToken(host, token)
+	kion.Reauthenticate = func() (string, time.Time, error) {
+		token, err := loginAndSave()
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		return token.Access, token.AccessExpiry, nil
+	}
+	return kion, nil
+}
+
+// savedToken returns the saved token for the given user if it's usable,
+// renewing it if needed, or else nil.
+func savedToken(storage string, host string, idms int, username string) *client.Token {
+	// a token that can't be read is no different from no token
+	token, err := LoadToken(storage, host, idms, username)
+	if err != nil || token == nil {
+		return nil
+	}
+
+	// a token without an expiry can't be known to be valid, so it's renewed;
+	// Kion checks the refresh token itself, so one without an expiry is tried
+	soon := time.Now().Add(tokenExpiryMargin)
+	if token.AccessExpiry.After(soon) {
+		return token
+	}
+	if token.Refresh == "" || (!token.RefreshExpiry.IsZero() && token.RefreshExpiry.Before(soon)) {
+		return nil
+	}
+
+	token, err = client.RefreshToken(host, token.Refresh)
+	if err != nil {
+		return nil
+	}
+	err = SaveToken(storage, host, idms, username, token)
+	if err != nil {
+		fmt.Fprintf(Warnings, "warning: saving token: %v\n", err)
+	}
+	return token
+}
+
+// LoadToken returns the saved token for the given user, or nil if there is
+// none. storage is a value of password-storage.
+func LoadToken(storage string, host string, idms int, username string) (*client.Token, error) {
+	tokenJSON, err := GetSecret(storage, tokenService, tokenUser(host, idms, username))
+	if keyringUnavailable(storage, err) {
+		tokenJSON, err = getFallbackToken(tokenUser(host, idms, username))
+	}
+	if errors.Is(err, keyring.ErrNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	token := client.Token{}
+	err = json.Unmarshal([]byte(tokenJSON), &token)
+	if err != nil {
+		return nil, fmt.Errorf("reading saved token: %w", err)
+	}
+	return &token, nil
+}
+
+// SaveToken saves the token for the given user. storage is a value of
+// password-storage.
+func SaveToken(storage string, host string, idms int, username string, token *client.Token) error {
+	tokenJSON, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	err = SetSecret(storage, tokenService, tokenUser(host, idms, username), string(tokenJSON))
+	if keyringUnavailable(storage, err) {
+		return setFallbackToken(tokenUser(host, idms, username), string(tokenJSON))
+	}
+	return err
+}
+
+// DeleteToken deletes the saved token for the given user, if any.
+func DeleteToken(storage string, host string, idms int, username string) error {
+	err := DeleteSecret(storage, tokenService, tokenUser(host, idms, username))
+	if keyringUnavailable(storage, err) {
+		err = setFallbackToken(tokenUser(host, idms, username), "")
+	}
+	if errors.Is(err, keyring.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// keyringUnavailable reports whether err is a failure to use the keyring as
+// opposed to a missing entry.
+func keyringUnavailable(storage string, err error) bool {
+	return (storage == "" || storage == PasswordStorageKeyring) && err != nil && !errors.Is(err, keyring.ErrNotFound)
+}
+
+// getFallbackToken reads a token from the secrets file when the keyring is
+// unavailable. Tokens are only a cache, so without a passphrase at hand it
+// reports no token rather than asking for one.
+func getFallbackToken(user string) (string, error) {
+	passphrase, ok := availablePassphrase()
+	if !ok {
+		return "", keyring.ErrNotFound
+	}
+	return config.GetSecret(passphrase, secretName(tokenService, user))
+}
+
+// setFallbackToken saves (or, if token is empty, deletes) a token in the
+// secrets file when the keyring is unavailable. Without a passphrase at hand,
+// the token isn't saved and the next run logs in again.
+func setFallbackToken(user string, token string) error {
+	passphrase, ok := availablePassphrase()
+	if !ok {
+		return nil
+	}
+	return config.SetSecret(passphrase, secretName(tokenService, user), token)
+}
+
+func tokenUser(host string, idms int, username string) string {
+	return fmt.Sprintf("%s/%s", KeyringService(host, idms), username)
+}
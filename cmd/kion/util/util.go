@@ -1,73 +1,447 @@
 package util
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"strings"
+	"text/template"
 	"time"
 
+	"github.com/AlecAivazis/survey/v2"
 	"github.com/corbaltcode/kion/cmd/kion/config"
 	"github.com/corbaltcode/kion/internal/client"
 	"github.com/zalando/go-keyring"
+	"golang.org/x/term"
 )
 
+// AppAPIKeyName is the default name of App API Keys created by this tool. Key
+// names are expected to start with it (see RenderAppAPIKeyName).
 const AppAPIKeyName = "Kion Tool"
 
+// App API Keys are rotated when they expire within RotationWindow.
+const RotationWindow = time.Hour * 72
+
+// Warnings receives warnings such as an App API Key nearing expiry. Commands
+// whose output is read by other programs may discard warnings.
+var Warnings io.Writer = os.Stderr
+
+// CheckAppAPIKeyHost returns an error if the key in keyCfg was created for a
+// host other than host, so that the key is never sent to a host named by an
+// unexpected config file. Keys saved by older versions don't record a host.
+func CheckAppAPIKeyHost(keyCfg *config.KeyConfig, host string) error {
+	if keyCfg.Host != "" && keyCfg.Host != host && (keyCfg.Key != "" || keyCfg.PendingKey != "") {
+		return fmt.Errorf("the App API Key is for %v, not %v; run \"kion key create --force\" to create one for %v", keyCfg.Host, host, host)
+	}
+	return nil
+}
+
+// keyConfigLoaded is set once LoadKeyConfig has read the key config.
+var keyConfigLoaded bool
+
+// LoadKeyConfig reads the key config into keyCfg the first time it's called,
+// moving the key to app-api-key-storage if the setting has changed. main leaves
+// the key config unloaded so that commands that don't use the key never touch
+// the keyring.
+func LoadKeyConfig(cfg *config.Config, keyCfg *config.KeyConfig) error {
+	if keyConfigLoaded {
+		return nil
+	}
+	loaded, err := config.LoadKeyConfig()
+	if err != nil {
+		return err
+	}
+	*keyCfg = *loaded
+	keyConfigLoaded = true
+
+	// the key still works where it is, so a failed move isn't fatal
+	err = keyCfg.SetStorage(cfg.String("app-api-key-storage"))
+	if err != nil {
+		fmt.Fprintf(Warnings, "warning: moving App API Key to %v storage: %v\n", cfg.String("app-api-key-storage"), err)
+	}
+	return nil
+}
+
 func NewClient(cfg *config.Config, keyCfg *config.KeyConfig) (*client.Client, error) {
 	host, err := cfg.StringErr("host")
 	if err != nil {
 		return nil, err
 	}
+	err = LoadKeyConfig(cfg, keyCfg)
+	if err != nil {
+		return nil, err
+	}
+	err = CheckAppAPIKeyHost(keyCfg, host)
+	if err != nil {
+		return nil, err
+	}
+
+	if keyCfg.PendingKey != "" {
+		// a previous creation or rotation was interrupted
+		err = CompletePendingAppAPIKey(keyCfg)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	if keyCfg.Key != "" {
-		appAPIKeyDuration, err := cfg.DurationErr("app-api-key-duration")
+		expiry, err := AppAPIKeyExpiry(cfg, keyCfg)
 		if err != nil {
 			return nil, err
 		}
 
-		if cfg.Bool("rotate-app-api-keys") {
-			expiry := keyCfg.Created.Add(appAPIKeyDuration)
+		autoRecreate := cfg.Bool("auto-recreate-app-api-key")
+		if autoRecreate && !time.Now().Before(expiry) {
+			err = RecreateAppAPIKey(cfg, keyCfg)
+		} else if cfg.Bool("rotate-app-api-keys") {
+			err = RotateAppAPIKey(cfg, keyCfg, false)
+		}
+		if err != nil {
+			return nil, err
+		}
 
-			// rotate if expiring within three days
-			if expiry.Before(time.Now().Add(time.Hour * 72)) {
-				kion := client.NewWithAppAPIKey(host, keyCfg.Key, expiry)
-				key, err := kion.RotateAppAPIKey(keyCfg.Key)
-				if err != nil {
-					return nil, err
-				}
+		expiry, err = AppAPIKeyExpiry(cfg, keyCfg)
+		if err != nil {
+			return nil, err
+		}
 
-				// can't know exact expiry before getting metadata, so pass zero Time meaning "no expiry"
-				kion = client.NewWithAppAPIKey(host, key.Key, time.Time{})
-				keyMetadata, err := kion.GetAppAPIKeyMetadata(key.ID)
-				if err != nil {
-					return nil, err
-				}
+		if !cfg.Bool("rotate-app-api-keys") {
+			window := RotationWindow
+			if cfg.Exists("app-api-key-warning-window") {
+				window = cfg.Duration("app-api-key-warning-window")
+			}
+			remaining := time.Until(expiry)
+			if remaining > 0 && remaining < window {
+				fmt.Fprintf(Warnings, "warning: App API Key expires in %v; run \"kion key rotate\"\n", remaining.Round(time.Minute))
+			}
+		}
 
-				keyCfg.Key = key.Key
-				keyCfg.Created = keyMetadata.Created
-				err = keyCfg.Save()
+		kion := client.NewWithAppAPIKey(host, keyCfg.Key, expiry)
+		if autoRecreate {
+			// the key may be rejected even if it hasn't expired (e.g. if
+			// app-api-key-duration is wrong)
+			kion.Reauthenticate = func() (string, time.Time, error) {
+				err := RecreateAppAPIKey(cfg, keyCfg)
 				if err != nil {
-					return nil, err
+					return "", time.Time{}, err
 				}
+				expiry, err := AppAPIKeyExpiry(cfg, keyCfg)
+				return keyCfg.Key, expiry, err
 			}
 		}
+		return kion, nil
+	}
+
+	return UserClient(cfg)
+}
 
-		return client.NewWithAppAPIKey(host, keyCfg.Key, keyCfg.Created.Add(appAPIKeyDuration)), nil
+// AppAPIKeyExpiry returns when the App API Key expires: the expiry reported by
+// Kion if known, or else the key's creation time plus app-api-key-duration.
+func AppAPIKeyExpiry(cfg *config.Config, keyCfg *config.KeyConfig) (time.Time, error) {
+	if !keyCfg.Expiry.IsZero() {
+		return keyCfg.Expiry, nil
 	}
+	appAPIKeyDuration, err := cfg.DurationErr("app-api-key-duration")
+	if err != nil {
+		return time.Time{}, err
+	}
+	return keyCfg.Created.Add(appAPIKeyDuration), nil
+}
 
-	idms, err := cfg.IntErr("idms")
+// lockTimeout is how long to wait for another process to finish with the App
+// API Key.
+const lockTimeout = 30 * time.Second
+
+// SaveAppAPIKey saves a newly created or rotated key in keyCfg. The key is
+// saved as pending before its metadata is requested, so that it isn't lost if
+// the request fails; CompletePendingAppAPIKey finishes saving it later.
+func SaveAppAPIKey(keyCfg *config.KeyConfig, host string, key *client.AppAPIKey) error {
+	keyCfg.Host = host
+	keyCfg.PendingID = key.ID
+	keyCfg.PendingKey = key.Key
+	err := keyCfg.Save()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	username, err := cfg.StringErr("username")
+	return completePendingAppAPIKey(keyCfg)
+}
+
+// CompletePendingAppAPIKey finishes saving a key left pending by an interrupted
+// creation or rotation.
+func CompletePendingAppAPIKey(keyCfg *config.KeyConfig) error {
+	unlock, err := LockAndReloadKeyConfig(keyCfg)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	defer unlock()
+
+	return completePendingAppAPIKey(keyCfg)
+}
 
-	password, err := keyring.Get(KeyringService(host, idms), username)
+func completePendingAppAPIKey(keyCfg *config.KeyConfig) error {
+	if keyCfg.PendingKey == "" {
+		return nil
+	}
+
+	// can't know exact expiry before getting metadata, so pass zero Time meaning "no expiry"
+	kion := client.NewWithAppAPIKey(keyCfg.Host, keyCfg.PendingKey, time.Time{})
+	keyMetadata, err := kion.GetAppAPIKeyMetadata(keyCfg.PendingID)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("saving new App API Key (will retry on next run): %w", err)
+	}
+
+	if keyCfg.ID != 0 && keyCfg.ID != keyCfg.PendingID {
+		keyCfg.PreviousIDs = append(keyCfg.PreviousIDs, keyCfg.ID)
+	}
+	keyCfg.ID = keyCfg.PendingID
+	keyCfg.Name = keyMetadata.Name
+	keyCfg.Key = keyCfg.PendingKey
+	keyCfg.Created = keyMetadata.Created
+	keyCfg.Expiry = keyMetadata.Expiry
+	keyCfg.PendingID = 0
+	keyCfg.PendingKey = ""
+	return keyCfg.Save()
+}
+
+// RotateAppAPIKey rotates the App API Key if it expires within RotationWindow,
+// or regardless of expiry if force is set. A lock keeps concurrent processes
+// from rotating the same key; a process that waited for the lock uses the key
+// rotated by the other process.
+func RotateAppAPIKey(cfg *config.Config, keyCfg *config.KeyConfig, force bool) error {
+	host, err := cfg.StringErr("host")
+	if err != nil {
+		return err
+	}
+
+	expiry, err := AppAPIKeyExpiry(cfg, keyCfg)
+	if err != nil {
+		return err
+	}
+	if !force && expiry.After(time.Now().Add(RotationWindow)) {
+		return nil
+	}
+
+	oldKey := keyCfg.Key
+	unlock, err := LockAndReloadKeyConfig(keyCfg)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	err = completePendingAppAPIKey(keyCfg)
+	if err != nil {
+		return err
+	}
+	if keyCfg.Key != oldKey {
+		// another process rotated the key while we waited
+		return nil
+	}
+
+	kion := client.NewWithAppAPIKey(host, keyCfg.Key, expiry)
+	key, err := kion.RotateAppAPIKey(keyCfg.Key)
+	if err != nil {
+		return err
+	}
+
+	return SaveAppAPIKey(keyCfg, host, key)
+}
+
+// RecreateAppAPIKey replaces an expired or rejected App API Key with a new one,
+// logging in with the user's credentials (see UserClient). A process that
+// waited for the lock uses the key created by the other process.
+func RecreateAppAPIKey(cfg *config.Config, keyCfg *config.KeyConfig) error {
+	host, err := cfg.StringErr("host")
+	if err != nil {
+		return err
+	}
+
+	oldKey := keyCfg.Key
+	unlock, err := LockAndReloadKeyConfig(keyCfg)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if keyCfg.Key != oldKey {
+		return nil
+	}
+
+	kion, err := UserClient(cfg)
+	if err != nil {
+		return fmt.Errorf("recreating App API Key: %w", err)
+	}
+	name, err := RenderAppAPIKeyName(cfg.String("app-api-key-name"), cfg.String("username"))
+	if err != nil {
+		return err
+	}
+	key, err := kion.CreateAppAPIKey(name)
+	if err != nil {
+		return fmt.Errorf("recreating App API Key: %w", err)
+	}
+
+	fmt.Fprintln(Warnings, "warning: App API Key expired or was rejected; created a new one")
+	oldID := keyCfg.ID
+	err = SaveAppAPIKey(keyCfg, host, key)
+	if err != nil || oldID == 0 || oldID == keyCfg.ID {
+		return err
+	}
+
+	// the old key is useless now; if it can't be revoked, "kion key prune" can
+	// try again later
+	err = kion.DeleteAppAPIKey(oldID)
+	if err != nil {
+		fmt.Fprintf(Warnings, "warning: revoking old App API Key %v: %v\n", oldID, err)
+		return nil
+	}
+	return forgetPreviousAppAPIKeys(keyCfg, []int{oldID})
+}
+
+// appAPIKeyNameData is passed to the app-api-key-name template.
+type appAPIKeyNameData struct {
+	Hostname string
+	Username string
+	User     string
+	Date     string
+	Profile  string
+}
+
+// RenderAppAPIKeyName executes the key name template tmpl, or returns
+// AppAPIKeyName if tmpl is empty. The template's fields are Hostname, Username
+// (the Kion username), User (the local login), Date (YYYY-MM-DD), and Profile
+// ($AWS_PROFILE). AppAPIKeyName is added to the start of the name if it isn't
+// there, so that keys created by this tool can be recognized.
+func RenderAppAPIKeyName(tmpl string, username string) (string, error) {
+	if tmpl == "" {
+		return AppAPIKeyName, nil
+	}
+	t, err := template.New("app-api-key-name").Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("parsing app-api-key-name: %w", err)
+	}
+
+	data := appAPIKeyNameData{
+		Username: username,
+		Date:     time.Now().Format("2006-01-02"),
+		Profile:  os.Getenv("AWS_PROFILE"),
+	}
+	data.Hostname, _ = os.Hostname()
+	if u, err := user.Current(); err == nil {
+		data.User = u.Username
+	}
+
+	name := new(strings.Builder)
+	err = t.Execute(name, data)
+	if err != nil {
+		return "", fmt.Errorf("executing app-api-key-name: %w", err)
+	}
+	rendered := strings.TrimSpace(name.String())
+	if !strings.HasPrefix(rendered, AppAPIKeyName) {
+		rendered = strings.TrimSpace(AppAPIKeyName + " " + rendered)
+	}
+	return rendered, nil
+}
+
+// Interactive reports whether the user may be prompted. Commands whose input
+// and output belong to other programs (e.g. credential-process) set it to false.
+var Interactive = true
+
+// UserPassword returns the user's password: the output of password-command if
+// it's set, or else the password saved in password-storage (the system keyring
+// by default). If no password is saved, the user is asked for it if Interactive
+// is set and stdin is a terminal.
+func UserPassword(cfg *config.Config, host string, idms int, username string) (string, error) {
+	if command := cfg.String("password-command"); command != "" {
+		return RunCommand(command)
 	}
 
-	return client.Login(host, idms, username, password)
+	password, err := GetSecret(cfg.String("password-storage"), KeyringService(host, idms), username)
+	if errors.Is(err, keyring.ErrNotFound) && Interactive && term.IsTerminal(int(os.Stdin.Fd())) {
+		err = survey.AskOne(
+			&survey.Password{Message: fmt.Sprintf("Password for '%v' on '%v' (IDMS %v):", username, host, idms)},
+			&password,
+			survey.WithValidator(survey.Required),
+		)
+	}
+	return password, err
+}
+
+// Login logs in with a password, supplying an MFA code if the IDMS asks for
+// one. The code is the output of mfaCommand if it's set, or else is asked for if
+// Interactive is set and stdin is a terminal; otherwise Login returns
+// client.ErrMFARequired.
+func Login(host string, idms int, username string, password string, mfaCommand string) (*client.Client, error) {
+	kion, err := client.Login(host, idms, username, password)
+	if !errors.Is(err, client.ErrMFARequired) {
+		return kion, err
+	}
+
+	var code string
+	if mfaCommand != "" {
+		code, err = RunCommand(mfaCommand)
+		if err != nil {
+			return nil, fmt.Errorf("getting MFA code: %w", err)
+		}
+	} else if Interactive && term.IsTerminal(int(os.Stdin.Fd())) {
+		err = survey.AskOne(
+			&survey.Input{Message: "MFA code:"},
+			&code,
+			survey.WithValidator(survey.Required),
+		)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, client.ErrMFARequired
+	}
+
+	return client.LoginMFA(host, idms, username, password, code)
+}
+
+// ForgetPreviousAppAPIKeys removes revoked keys from keyCfg.PreviousIDs.
+func ForgetPreviousAppAPIKeys(keyCfg *config.KeyConfig, ids []int) error {
+	unlock, err := LockAndReloadKeyConfig(keyCfg)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return forgetPreviousAppAPIKeys(keyCfg, ids)
+}
+
+func forgetPreviousAppAPIKeys(keyCfg *config.KeyConfig, ids []int) error {
+	revoked := map[int]bool{}
+	for _, id := range ids {
+		revoked[id] = true
+	}
+	previous := []int{}
+	for _, id := range keyCfg.PreviousIDs {
+		if !revoked[id] {
+			previous = append(previous, id)
+		}
+	}
+	if len(previous) == len(keyCfg.PreviousIDs) {
+		return nil
+	}
+	keyCfg.PreviousIDs = previous
+	return keyCfg.Save()
+}
+
+// LockAndReloadKeyConfig locks the key config and reloads it, since another
+// process may have changed it. The lock is released by calling unlock.
+func LockAndReloadKeyConfig(keyCfg *config.KeyConfig) (unlock func(), err error) {
+	unlock, err = config.LockKeyConfig(lockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	current, err := config.LoadKeyConfig()
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+	*keyCfg = *current
+	return unlock, nil
 }
 
 func KeyringService(host string, idms int) string {
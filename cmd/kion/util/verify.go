@@ -0,0 +1,39 @@
+package util
+
+import (
+	"fmt"
+
+	"github.com/corbaltcode/kion/internal/client"
+	"github.com/corbaltcode/kion/internal/sts"
+)
+
+// VerifyCredentials confirms that creds for the given account work by calling
+// STS GetCallerIdentity in the account's partition, returning the identity.
+func VerifyCredentials(kion *client.Client, accountID string, creds *client.TemporaryCredentials) (*sts.Identity, error) {
+	account, err := kion.GetAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	partition, err := account.Type.Partition()
+	if err != nil {
+		return nil, err
+	}
+	endpoint, err := sts.EndpointForPartition(partition)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := sts.GetCallerIdentity(endpoint, sts.Credentials{
+		AccessKeyID:     creds.AccessKeyID,
+		SecretAccessKey: creds.SecretAccessKey,
+		SessionToken:    creds.SessionToken,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("verifying credentials: %w", err)
+	}
+	if id.Account != accountID {
+		return nil, fmt.Errorf("verifying credentials: got credentials for account %v (want %v)", id.Account, accountID)
+	}
+	return id, nil
+}
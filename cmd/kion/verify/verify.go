@@ -0,0 +1,52 @@
+package verify
+
+import (
+	"fmt"
+
+	"github.com/corbaltcode/kion/cmd/kion/config"
+	"github.com/corbaltcode/kion/cmd/kion/util"
+	"github.com/spf13/cobra"
+)
+
+func New(cfg *config.Config, keyCfg *config.KeyConfig) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Checks that temporary credentials work and prints the assumed role's ARN",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return run(cfg, keyCfg)
+		},
+	}
+
+	cmd.Flags().StringP("account-id", "", "", "AWS account ID")
+	cmd.Flags().StringP("cloud-access-role", "", "", "cloud access role")
+
+	return cmd
+}
+
+func run(cfg *config.Config, keyCfg *config.KeyConfig) error {
+	accountID, err := cfg.StringErr("account-id")
+	if err != nil {
+		return err
+	}
+	cloudAccessRole, err := cfg.StringErr("cloud-access-role")
+	if err != nil {
+		return err
+	}
+
+	kion, err := util.NewClient(cfg, keyCfg)
+	if err != nil {
+		return err
+	}
+	creds, err := kion.GetTemporaryCredentialsByCloudAccessRole(accountID, cloudAccessRole)
+	if err != nil {
+		return err
+	}
+
+	id, err := util.VerifyCredentials(kion, accountID, creds)
+	if err != nil {
+		return err
+	}
+	fmt.Println(id.Arn)
+	return nil
+}
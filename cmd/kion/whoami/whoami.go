@@ -0,0 +1,175 @@
+package whoami
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/corbaltcode/kion/cmd/kion/config"
+	"github.com/corbaltcode/kion/cmd/kion/util"
+	"github.com/corbaltcode/kion/internal/client"
+	"github.com/spf13/cobra"
+)
+
+func New(cfg *config.Config, keyCfg *config.KeyConfig) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "whoami",
+		Short: "Prints the Kion user and how the tool authenticates",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return run(cfg, keyCfg)
+		},
+	}
+
+	cmd.Flags().StringP("format", "f", "text", "format (text or json)")
+
+	return cmd
+}
+
+// Values of identity.AuthMode.
+const (
+	authModeAppAPIKey = "app-api-key"
+	authModePassword  = "password"
+	authModeSSO       = "sso"
+)
+
+type identity struct {
+	Host     string       `json:"host"`
+	IDMS     *idms        `json:"idms,omitempty"`
+	Username string       `json:"username,omitempty"`
+	AuthMode string       `json:"auth_mode"`
+	Key      *key         `json:"app_api_key,omitempty"`
+	User     *client.User `json:"user"`
+}
+
+type idms struct {
+	ID   int    `json:"id"`
+	Name string `json:"name,omitempty"`
+}
+
+type key struct {
+	ID      int       `json:"id,omitempty"`
+	Name    string    `json:"name,omitempty"`
+	Created time.Time `json:"created"`
+	Expiry  time.Time `json:"expiry"`
+}
+
+func run(cfg *config.Config, keyCfg *config.KeyConfig) error {
+	format, err := cfg.StringErr("format")
+	if err != nil {
+		return err
+	}
+	if format != "text" && format != "json" {
+		return fmt.Errorf("invalid format: %v", format)
+	}
+	host, err := cfg.StringErr("host")
+	if err != nil {
+		return err
+	}
+
+	id := identity{
+		Host:     host,
+		Username: cfg.String("username"),
+	}
+
+	if idmsID := cfg.Int("idms"); idmsID != 0 {
+		id.IDMS = &idms{ID: idmsID}
+		// the name is a nicety; the ID is enough if the list is unavailable
+		idmss, err := client.GetIDMSs(host)
+		if err == nil {
+			for _, i := range idmss {
+				if i.ID == idmsID {
+					id.IDMS.Name = i.Name
+				}
+			}
+		}
+	}
+
+	kion, err := util.NewClient(cfg, keyCfg)
+	if err != nil {
+		return err
+	}
+
+	// NewClient may have rotated or recreated the key, so check afterward
+	if keyCfg.Key != "" {
+		id.AuthMode = authModeAppAPIKey
+		expiry, err := util.AppAPIKeyExpiry(cfg, keyCfg)
+		if err != nil {
+			return err
+		}
+		id.Key = &key{
+			ID:      keyCfg.ID,
+			Name:    keyCfg.Name,
+			Created: keyCfg.Created,
+			Expiry:  expiry,
+		}
+	} else if cfg.String("login-method") == util.LoginMethodSSO {
+		id.AuthMode = authModeSSO
+	} else {
+		id.AuthMode = authModePassword
+	}
+
+	id.User, err = kion.GetCurrentUser()
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case "text":
+		printText(id)
+	case "json":
+		err = json.NewEncoder(os.Stdout).Encode(id)
+		if err != nil {
+			return err
+		}
+	default:
+		panic(fmt.Sprintf("unexpected format: %v", format))
+	}
+	return nil
+}
+
+func printText(id identity) {
+	fmt.Printf("Host:       %v\n", id.Host)
+	if id.IDMS != nil {
+		if id.IDMS.Name != "" {
+			fmt.Printf("IDMS:       %v (%v)\n", id.IDMS.Name, id.IDMS.ID)
+		} else {
+			fmt.Printf("IDMS:       %v\n", id.IDMS.ID)
+		}
+	}
+	if id.Username != "" {
+		fmt.Printf("Username:   %v\n", id.Username)
+	}
+
+	switch id.AuthMode {
+	case authModeAppAPIKey:
+		keyID := "unknown"
+		if id.Key.ID != 0 {
+			keyID = strconv.Itoa(id.Key.ID)
+		}
+		fmt.Printf("Auth:       App API Key (ID %v)\n", keyID)
+		fmt.Printf("Key age:    %v\n", time.Since(id.Key.Created).Round(time.Minute))
+		remaining := time.Until(id.Key.Expiry).Round(time.Minute)
+		if remaining > 0 {
+			fmt.Printf("Key expiry: %v (in %v)\n", id.Key.Expiry.Local().Format(time.RFC1123), remaining)
+		} else {
+			fmt.Printf("Key expiry: %v (expired)\n", id.Key.Expiry.Local().Format(time.RFC1123))
+		}
+	case authModeSSO:
+		fmt.Printf("Auth:       SSO\n")
+	default:
+		fmt.Printf("Auth:       user password\n")
+	}
+
+	name := strings.TrimSpace(id.User.FirstName + " " + id.User.LastName)
+	if name == "" {
+		name = id.User.Username
+	}
+	if id.User.Email != "" {
+		name = fmt.Sprintf("%v <%v>", name, id.User.Email)
+	}
+	fmt.Printf("User:       %v (ID %v, username %v)\n", name, id.User.ID, id.User.Username)
+}
@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/relvacode/iso8601"
@@ -14,11 +16,26 @@ import (
 
 var ErrAppAPIKeyExpired = errors.New("kion: app API key expired")
 var ErrInvalidCredentials = errors.New("kion: invalid credentials")
+var ErrMFARequired = errors.New("kion: MFA code required")
 var ErrUnauthorized = errors.New("kion: unauthorized")
 
+// A Client is safe for concurrent use.
 type Client struct {
-	Host        string
+	Host string
+
+	// mu guards accessToken and token, which change when the Client
+	// reauthenticates.
+	mu          sync.Mutex
 	accessToken *accessToken
+	// token is the user's token if the Client authenticates as a user.
+	token *Token
+
+	// Reauthenticate, if set, is called when a request fails because the App
+	// API Key or user token has expired or was rejected. It returns a new key
+	// or token of the same kind and its expiry (zero for none), and the request
+	// is retried once with it. Kion doesn't perform a request it rejects, so
+	// any request may be retried.
+	Reauthenticate func() (key string, expiry time.Time, err error)
 }
 
 type AccountCloudAccessRole struct {
@@ -34,7 +51,10 @@ type AppAPIKey struct {
 
 type AppAPIKeyMetadata struct {
 	ID      int
+	Name    string
 	Created time.Time
+	// Expiry is zero if Kion doesn't report when the key expires.
+	Expiry time.Time
 }
 
 type IDMS struct {
@@ -48,6 +68,14 @@ type TemporaryCredentials struct {
 	SessionToken    string `json:"session_token"`
 }
 
+type User struct {
+	ID        int    `json:"id"`
+	Username  string `json:"username"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email"`
+}
+
 type AccountType int
 
 const (
@@ -55,12 +83,34 @@ const (
 	AccountTypeGovCloud   AccountType = 2
 )
 
+// Partition returns the name of the AWS partition of accounts of type t ("aws"
+// or "aws-us-gov").
+func (t AccountType) Partition() (string, error) {
+	switch t {
+	case AccountTypeCommercial:
+		return "aws", nil
+	case AccountTypeGovCloud:
+		return "aws-us-gov", nil
+	default:
+		return "", fmt.Errorf("unexpected account type: %d", t)
+	}
+}
+
 type Account struct {
 	Name string      `json:"account_name"`
 	ID   string      `json:"account_number"`
 	Type AccountType `json:"account_type_id"`
 }
 
+// Token is a bearer token obtained by logging in as a user, along with the
+// refresh token used to renew it. An expiry is zero if Kion doesn't report it.
+type Token struct {
+	Access        string
+	AccessExpiry  time.Time
+	Refresh       string
+	RefreshExpiry time.Time
+}
+
 type accessToken struct {
 	Token       string
 	Expiry      time.Time
@@ -85,33 +135,134 @@ func NewWithAppAPIKey(host string, key string, expiry time.Time) *Client {
 	}
 }
 
-// TODO: how to use the refresh token (currently dropped)?
+// NewWithToken creates a Client that authenticates with a user's token (see
+// Login, RefreshToken, and SAMLLoginURL).
+func NewWithToken(host string, token *Token) *Client {
+	return &Client{
+		Host: host,
+		accessToken: &accessToken{
+			Token:       token.Access,
+			Expiry:      token.AccessExpiry,
+			IsAppAPIKey: false,
+		},
+		token: token,
+	}
+}
+
+// Login logs in with a password. If the IDMS requires multi-factor
+// authentication, Login returns ErrMFARequired; use LoginMFA instead.
 func Login(host string, idms int, username string, password string) (*Client, error) {
+	return LoginMFA(host, idms, username, password, "")
+}
+
+// LoginMFA logs in with a password and a multi-factor authentication code (e.g.
+// from a TOTP app). An empty code is not sent.
+func LoginMFA(host string, idms int, username string, password string, code string) (*Client, error) {
 	req := map[string]interface{}{
 		"idms":     idms,
 		"username": username,
 		"password": password,
 	}
-	resp := struct {
-		Access struct {
-			Token string
-		}
-	}{}
+	if code != "" {
+		req["mfa_code"] = code
+	}
+	resp := tokenResponse{}
 
 	err := do(http.MethodPost, host, nil, "v3/token", req, &resp)
+	var kionResp kionResponse
+	if errors.As(err, &kionResp) && isMFAChallenge(kionResp) {
+		return nil, ErrMFARequired
+	} else if err != nil {
+		return nil, err
+	}
+
+	token, err := resp.token()
 	if err != nil {
 		return nil, err
 	}
+	return NewWithToken(host, token), nil
+}
 
-	client := Client{
-		Host: host,
-		accessToken: &accessToken{
-			Token:       resp.Access.Token,
-			Expiry:      time.Time{},
-			IsAppAPIKey: false,
-		},
+// RefreshToken exchanges a refresh token for a new token.
+func RefreshToken(host string, refresh string) (*Token, error) {
+	req := map[string]interface{}{
+		"key": refresh,
+	}
+	resp := tokenResponse{}
+
+	err := do(http.MethodPost, host, nil, "v3/token/refresh", req, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.token()
+}
+
+// SAMLLoginURL returns the URL at which a user logs in through a SAML IDMS in a
+// browser. After login, Kion has the browser post the token to redirectURL as
+// a form (see ParseSAMLCallback).
+func SAMLLoginURL(host string, idms int, redirectURL string) string {
+	u := url.URL{
+		Scheme:   "https",
+		Host:     host,
+		Path:     fmt.Sprintf("api/v1/saml/auth/%d", idms),
+		RawQuery: url.Values{"redirect_url": {redirectURL}}.Encode(),
+	}
+	return u.String()
+}
+
+// ParseSAMLCallback returns the token in the form posted to the redirect URL
+// given to SAMLLoginURL.
+func ParseSAMLCallback(form url.Values) (*Token, error) {
+	resp := tokenResponse{}
+	resp.Access.Token = form.Get("access_token")
+	resp.Access.ExpiryISO8601 = form.Get("access_token_expiry")
+	resp.Refresh.Token = form.Get("refresh_token")
+	resp.Refresh.ExpiryISO8601 = form.Get("refresh_token_expiry")
+	if resp.Access.Token == "" {
+		return nil, errors.New("kion: SAML login didn't return a token")
 	}
-	return &client, nil
+	return resp.token()
+}
+
+// Token returns the user's token, or nil if the Client authenticates with an
+// App API Key.
+func (c *Client) Token() *Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.token
+}
+
+type tokenResponse struct {
+	Access struct {
+		Token         string
+		ExpiryISO8601 string `json:"expiry"`
+	}
+	Refresh struct {
+		Token         string
+		ExpiryISO8601 string `json:"expiry"`
+	}
+}
+
+func (r tokenResponse) token() (*Token, error) {
+	token := Token{
+		Access:  r.Access.Token,
+		Refresh: r.Refresh.Token,
+	}
+	var err error
+	if r.Access.ExpiryISO8601 != "" {
+		token.AccessExpiry, err = iso8601.ParseString(r.Access.ExpiryISO8601)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if r.Refresh.ExpiryISO8601 != "" {
+		token.RefreshExpiry, err = iso8601.ParseString(r.Refresh.ExpiryISO8601)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &token, nil
 }
 
 func GetIDMSs(host string) ([]IDMS, error) {
@@ -154,27 +305,82 @@ func (c *Client) RotateAppAPIKey(key string) (*AppAPIKey, error) {
 }
 
 func (c *Client) GetAppAPIKeyMetadata(id int) (*AppAPIKeyMetadata, error) {
-	resp := struct {
-		ID             int
-		CreatedISO8601 string `json:"created_at"`
-	}{}
+	resp := appAPIKeyMetadataResponse{}
 
 	err := c.do(http.MethodGet, fmt.Sprintf("v3/app-api-key/%d", id), nil, &resp)
 	if err != nil {
 		return nil, err
 	}
 
-	created, err := iso8601.ParseString(resp.CreatedISO8601)
+	return resp.metadata()
+}
+
+// ListAppAPIKeys returns the metadata of the current user's App API Keys.
+func (c *Client) ListAppAPIKeys() ([]AppAPIKeyMetadata, error) {
+	resp := []appAPIKeyMetadataResponse{}
+
+	err := c.do(http.MethodGet, "v3/app-api-key", nil, &resp)
 	if err != nil {
 		return nil, err
 	}
 
+	keys := []AppAPIKeyMetadata{}
+	for _, r := range resp {
+		metadata, err := r.metadata()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *metadata)
+	}
+	return keys, nil
+}
+
+func (c *Client) DeleteAppAPIKey(id int) error {
+	return c.do(http.MethodDelete, fmt.Sprintf("v3/app-api-key/%d", id), nil, nil)
+}
+
+type appAPIKeyMetadataResponse struct {
+	ID             int
+	Name           string
+	CreatedISO8601 string `json:"created_at"`
+	ExpiresISO8601 string `json:"expires_at"`
+}
+
+func (r appAPIKeyMetadataResponse) metadata() (*AppAPIKeyMetadata, error) {
+	created, err := iso8601.ParseString(r.CreatedISO8601)
+	if err != nil {
+		return nil, err
+	}
+
+	// older versions of Kion don't report expiry
+	var expiry time.Time
+	if r.ExpiresISO8601 != "" {
+		expiry, err = iso8601.ParseString(r.ExpiresISO8601)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &AppAPIKeyMetadata{
-		ID:      resp.ID,
+		ID:      r.ID,
+		Name:    r.Name,
 		Created: created,
+		Expiry:  expiry,
 	}, nil
 }
 
+// GetCurrentUser returns the profile of the user the Client authenticates as.
+func (c *Client) GetCurrentUser() (*User, error) {
+	resp := User{}
+
+	err := c.do(http.MethodGet, "v3/me", nil, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
 func (c *Client) GetAccountCloudAccessRoles() ([]AccountCloudAccessRole, error) {
 	resp := []AccountCloudAccessRole{}
 
@@ -238,8 +444,64 @@ func (r kionResponse) Error() string {
 	return fmt.Sprintf("kion: %v (%v)", r.Message, r.Status)
 }
 
+// Is lets a 401 response match ErrUnauthorized while keeping the message, which
+// LoginMFA inspects.
+func (r kionResponse) Is(target error) bool {
+	return target == ErrUnauthorized && r.Status == 401
+}
+
 func (c *Client) do(method string, path string, data interface{}, out interface{}) error {
-	return do(method, c.Host, c.accessToken, path, data, out)
+	c.mu.Lock()
+	token := c.accessToken
+	c.mu.Unlock()
+
+	err := do(method, c.Host, token, path, data, out)
+	if c.Reauthenticate == nil {
+		return err
+	}
+	// an expired key is caught before the request is sent, and a rejected
+	// request isn't performed, so retrying is safe either way
+	if !errors.Is(err, ErrAppAPIKeyExpired) && !errors.Is(err, ErrUnauthorized) {
+		return err
+	}
+
+	token, err = c.reauthenticate(token)
+	if err != nil {
+		return err
+	}
+	return do(method, c.Host, token, path, data, out)
+}
+
+// reauthenticate replaces the rejected token failed, unless a concurrent
+// request already replaced it, and returns the new token.
+func (c *Client) reauthenticate(failed *accessToken) (*accessToken, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.accessToken != failed {
+		return c.accessToken, nil
+	}
+
+	key, expiry, err := c.Reauthenticate()
+	if err != nil {
+		return nil, err
+	}
+	c.accessToken = &accessToken{
+		Token:       key,
+		Expiry:      expiry,
+		IsAppAPIKey: failed.IsAppAPIKey,
+	}
+	if !failed.IsAppAPIKey {
+		c.token = &Token{Access: key, AccessExpiry: expiry}
+	}
+	return c.accessToken, nil
+}
+
+// isMFAChallenge reports whether Kion rejected a login for want of an MFA code.
+// Kion reports this only in the message, which varies by IDMS.
+func isMFAChallenge(r kionResponse) bool {
+	message := strings.ToLower(r.Message)
+	return (r.Status == 400 || r.Status == 401) &&
+		(strings.Contains(message, "mfa") || strings.Contains(message, "multi-factor") || strings.Contains(message, "one-time"))
 }
 
 func do(method string, host string, accessToken *accessToken, path string, data interface{}, out interface{}) error {
@@ -286,8 +548,6 @@ func do(method string, host string, accessToken *accessToken, path string, data
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		if kionResp.Status == 400 && kionResp.Message == "Invalid username or password." {
 			return ErrInvalidCredentials
-		} else if kionResp.Status == 401 {
-			return ErrUnauthorized
 		} else {
 			return kionResp
 		}
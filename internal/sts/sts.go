@@ -0,0 +1,191 @@
+// Package sts calls the AWS Security Token Service without the AWS SDK.
+package sts
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Endpoint is an STS endpoint and the region requests to it are signed for.
+type Endpoint struct {
+	URL    string
+	Region string
+}
+
+// Endpoints of the global STS service in each partition.
+var (
+	Commercial = Endpoint{URL: "https://sts.amazonaws.com", Region: "us-east-1"}
+	GovCloud   = Endpoint{URL: "https://sts.us-gov-west-1.amazonaws.com", Region: "us-gov-west-1"}
+)
+
+// EndpointForPartition returns the global STS endpoint of the named partition
+// (e.g. "aws").
+func EndpointForPartition(partition string) (Endpoint, error) {
+	switch partition {
+	case "aws":
+		return Commercial, nil
+	case "aws-us-gov":
+		return GovCloud, nil
+	default:
+		return Endpoint{}, fmt.Errorf("unsupported partition: %v", partition)
+	}
+}
+
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+type Identity struct {
+	Account string
+	Arn     string
+	UserID  string `xml:"UserId"`
+}
+
+// Error is an error returned by STS.
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("sts: %v: %v (%v)", e.Code, e.Message, e.StatusCode)
+}
+
+// GetCallerIdentity returns the identity of the credentials' owner, which
+// confirms that the credentials work.
+func GetCallerIdentity(endpoint Endpoint, creds Credentials) (*Identity, error) {
+	body := url.Values{
+		"Action":  {"GetCallerIdentity"},
+		"Version": {"2011-06-15"},
+	}.Encode()
+
+	req, err := http.NewRequest(http.MethodPost, endpoint.URL, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+	sign(req, []byte(body), creds, endpoint.Region, "sts", time.Now())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		errResp := struct {
+			Error struct {
+				Code    string
+				Message string
+			}
+		}{}
+		// the status is reported even if the body isn't an STS error
+		xml.Unmarshal(respBody, &errResp)
+		return nil, &Error{StatusCode: resp.StatusCode, Code: errResp.Error.Code, Message: errResp.Error.Message}
+	}
+
+	out := struct {
+		Result Identity `xml:"GetCallerIdentityResult"`
+	}{}
+	err = xml.Unmarshal(respBody, &out)
+	if err != nil {
+		return nil, err
+	}
+	if out.Result.Arn == "" {
+		return nil, errors.New("sts: no identity in response")
+	}
+	return &out.Result, nil
+}
+
+// sign adds a Signature Version 4 Authorization header to req, signing the Host
+// header and all headers already set.
+//
+// https://docs.aws.amazon.com/IAM/latest/UserGuide/create-signed-request.html
+func sign(req *http.Request, body []byte, creds Credentials, region string, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := []string{}
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	canonicalHeaders := new(strings.Builder)
+	for _, name := range names {
+		fmt.Fprintf(canonicalHeaders, "%v:%v\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hexSHA256(body),
+	}, "\n")
+
+	scope := fmt.Sprintf("%v/%v/%v/aws4_request", date, region, service)
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hexSHA256([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%v/%v, SignedHeaders=%v, Signature=%v",
+		creds.AccessKeyID, scope, signedHeaders, signature,
+	))
+}
+
+func canonicalQuery(query url.Values) string {
+	// url.Values.Encode sorts by key but encodes spaces as "+"
+	return strings.ReplaceAll(query.Encode(), "+", "%20")
+}
+
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
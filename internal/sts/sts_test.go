@@ -0,0 +1,73 @@
+package sts
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	// get-vanilla from the AWS Signature Version 4 test suite
+	req, err := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds := Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	sign(req, nil, creds, "us-east-1", "service", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Fatalf("got %v (want %v)", got, want)
+	}
+}
+
+func TestGetCallerIdentity(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Authorization"), "Credential=AKID/") {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`<ErrorResponse><Error><Code>InvalidClientTokenId</Code><Message>bad key</Message></Error></ErrorResponse>`))
+			return
+		}
+		if r.Header.Get("X-Amz-Security-Token") != "token" {
+			t.Errorf("missing session token")
+		}
+		w.Write([]byte(`<GetCallerIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <GetCallerIdentityResult>
+    <Arn>arn:aws:sts::123412341234:assumed-role/role/session</Arn>
+    <UserId>AROAEXAMPLE:session</UserId>
+    <Account>123412341234</Account>
+  </GetCallerIdentityResult>
+</GetCallerIdentityResponse>`))
+	}))
+	defer server.Close()
+	endpoint := Endpoint{URL: server.URL, Region: "us-east-1"}
+
+	id, err := GetCallerIdentity(endpoint, Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret", SessionToken: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Identity{Account: "123412341234", Arn: "arn:aws:sts::123412341234:assumed-role/role/session", UserID: "AROAEXAMPLE:session"}
+	if *id != want {
+		t.Fatalf("got %+v (want %+v)", *id, want)
+	}
+
+	_, err = GetCallerIdentity(endpoint, Credentials{AccessKeyID: "OTHER", SecretAccessKey: "secret", SessionToken: "token"})
+	if stsErr, ok := err.(*Error); !ok || stsErr.Code != "InvalidClientTokenId" {
+		t.Fatalf("got %v (want InvalidClientTokenId)", err)
+	}
+}
+
+func TestEndpointForPartition(t *testing.T) {
+	for partition, want := range map[string]Endpoint{"aws": Commercial, "aws-us-gov": GovCloud} {
+		got, err := EndpointForPartition(partition)
+		if err != nil || got != want {
+			t.Errorf("%v: got %v, %v (want %v)", partition, got, err, want)
+		}
+	}
+	_, err := EndpointForPartition("aws-cn")
+	if err == nil {
+		t.Error("got endpoint for unsupported partition")
+	}
+}